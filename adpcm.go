@@ -0,0 +1,97 @@
+package ppm
+
+// Flipnote Studio stores sound as 4-bit IMA ADPCM, mono, at 8192 Hz. Each byte holds two
+// samples with the low nibble first, and every track starts with a predictor and step
+// index of 0.
+
+const SampleRate = 8192
+
+var adpcmIndexTable = [16]int{
+	-1, -1, -1, -1, 2, 4, 6, 8,
+	-1, -1, -1, -1, 2, 4, 6, 8,
+}
+
+var adpcmStepTable = [89]int{
+	7, 8, 9, 10, 11, 12, 13, 14, 16, 17,
+	19, 21, 23, 25, 28, 31, 34, 37, 41, 45,
+	50, 55, 60, 66, 73, 80, 88, 97, 107, 118,
+	130, 143, 157, 173, 190, 209, 230, 253, 279, 307,
+	337, 371, 408, 449, 494, 544, 598, 658, 724, 796,
+	876, 963, 1060, 1166, 1282, 1411, 1552, 1707, 1878, 2066,
+	2272, 2499, 2749, 3024, 3327, 3660, 4026, 4428, 4871, 5358,
+	5894, 6484, 7132, 7845, 8630, 9493, 10442, 11487, 12635, 13899,
+	15289, 16818, 18500, 20350, 22385, 24623, 27086, 29794, 32767,
+}
+
+type adpcmState struct {
+	predictor int
+	stepIndex int
+}
+
+// decodeNibble updates the state with one ADPCM code and returns the resulting sample
+func (state *adpcmState) decodeNibble(nibble byte) int16 {
+	step := adpcmStepTable[state.stepIndex]
+	diff := step >> 3
+	if nibble & 0x1 != 0 { diff += step >> 2 }
+	if nibble & 0x2 != 0 { diff += step >> 1 }
+	if nibble & 0x4 != 0 { diff += step }
+	if nibble & 0x8 != 0 {
+		state.predictor -= diff
+	} else {
+		state.predictor += diff
+	}
+	if state.predictor > 32767 { state.predictor = 32767 }
+	if state.predictor < -32768 { state.predictor = -32768 }
+
+	state.stepIndex += adpcmIndexTable[nibble & 0xF]
+	if state.stepIndex < 0 { state.stepIndex = 0 }
+	if state.stepIndex > 88 { state.stepIndex = 88 }
+	return int16(state.predictor)
+}
+
+// encodeSample picks the ADPCM code that best approximates sample and updates the state as the decoder would
+func (state *adpcmState) encodeSample(sample int16) byte {
+	step := adpcmStepTable[state.stepIndex]
+	diff := int(sample) - state.predictor
+	nibble := byte(0)
+	if diff < 0 {
+		nibble = 0x8
+		diff = -diff
+	}
+	for mask := byte(0x4); mask > 0; mask >>= 1 {
+		if diff >= step {
+			nibble |= mask
+			diff -= step
+		}
+		step >>= 1
+	}
+	state.decodeNibble(nibble)
+	return nibble
+}
+
+// DecodeADPCM decodes a Flipnote Studio ADPCM track into 16-bit PCM samples at SampleRate
+func DecodeADPCM(data []byte) []int16 {
+	state := &adpcmState{}
+	pcm := make([]int16, 0, len(data) * 2)
+	for _, adpcmByte := range data {
+		pcm = append(pcm, state.decodeNibble(adpcmByte & 0xF))
+		pcm = append(pcm, state.decodeNibble(adpcmByte >> 4))
+	}
+	return pcm
+}
+
+// EncodeADPCM encodes 16-bit PCM samples at SampleRate into a Flipnote Studio ADPCM track.
+// An odd number of samples is padded with a zero code.
+func EncodeADPCM(pcm []int16) []byte {
+	state := &adpcmState{}
+	data := make([]byte, (len(pcm) + 1) / 2)
+	for i, sample := range pcm {
+		nibble := state.encodeSample(sample)
+		if i % 2 == 0 {
+			data[i / 2] = nibble
+		} else {
+			data[i / 2] |= nibble << 4
+		}
+	}
+	return data
+}
@@ -0,0 +1,67 @@
+package ppm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecodeADPCM(t *testing.T) {
+	saturated := make([]byte, 40)
+	for i := range saturated {
+		saturated[i] = 0x77
+	}
+	adpcmCases := []struct {
+		name string
+		data []byte
+		want []int16
+	}{
+		// Worked through by hand from the step and index tables, starting from a predictor and step index of 0
+		{"low-nibble-first", []byte{0xF7, 0x80}, []int16{11, -19, -15, -18}},
+		{"smallest-step", []byte{0x00, 0x88}, []int16{0, 0, 0, 0}},
+		{"one-step", []byte{0x04}, []int16{7, 8}},
+		{"clamped", saturated, nil},
+	}
+
+	for _, adpcmCase := range adpcmCases {
+		t.Run(adpcmCase.name, func(t *testing.T) {
+			pcm := DecodeADPCM(adpcmCase.data)
+			if len(pcm) != len(adpcmCase.data) * 2 {
+				t.Fatalf("%d samples, want %d", len(pcm), len(adpcmCase.data) * 2)
+			}
+			if adpcmCase.want == nil {
+				if pcm[len(pcm) - 1] != 32767 {
+					t.Fatalf("last sample = %d, want 32767", pcm[len(pcm) - 1])
+				}
+				return
+			}
+			for i := range adpcmCase.want {
+				if pcm[i] != adpcmCase.want[i] {
+					t.Fatalf("samples = %v, want %v", pcm, adpcmCase.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeADPCM(t *testing.T) {
+	if data := EncodeADPCM([]int16{100, 200, 300}); len(data) != 2 || data[1] >> 4 != 0 {
+		t.Fatalf("odd sample count encoded as %X", data)
+	}
+
+	// A tone survives the round trip well above the noise
+	pcm := make([]int16, SampleRate)
+	for i := range pcm {
+		pcm[i] = int16(12000 * math.Sin(2 * math.Pi * 440 * float64(i) / SampleRate) + 4000 * math.Sin(2 * math.Pi * 1250 * float64(i) / SampleRate))
+	}
+	decoded := DecodeADPCM(EncodeADPCM(pcm))
+	signal, noise := 0.0, 0.0
+	for i := range pcm {
+		signal += float64(pcm[i]) * float64(pcm[i])
+		noise += (float64(pcm[i]) - float64(decoded[i])) * (float64(pcm[i]) - float64(decoded[i]))
+	}
+	if snr := 10 * math.Log10(signal / noise); snr < 20 {
+		t.Fatalf("SNR = %.1f dB, want at least 20 dB", snr)
+	} else {
+		t.Logf("SNR = %.1f dB", snr)
+	}
+}
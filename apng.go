@@ -0,0 +1,148 @@
+package ppm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image/png"
+	"io"
+)
+
+type APNGOptions struct {
+	Scale int // Integer scale factor, 1 if unset
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// EncodeAPNG writes every frame of p as a lossless animated PNG. Frame delays are stored as the
+// exact fraction of a second the frame speed gives, and the animation plays once unless the
+// animation flags say it loops.
+func EncodeAPNG(w io.Writer, p *PPM, opts APNGOptions) error {
+	timing := p.Timing()
+	if !timing.Valid() {
+		return ErrBadFrameSpeed
+	}
+	frameCount := p.frameCount()
+	if frameCount == 0 {
+		return ErrNoFrameData
+	}
+	scale := opts.Scale
+	if scale < 1 {
+		scale = 1
+	}
+	numerator, denominator := timing.FrameRateFraction()
+
+	if _, err := w.Write(pngSignature); err != nil {
+		return err
+	}
+
+	sequenceN := uint32(0)
+	for frameN := 0; frameN < frameCount; frameN++ {
+		frameLayers, err := p.FrameLayers(frameN)
+		if err != nil {
+			return err
+		}
+		frameImage := getFrameImage(frameLayers, p.FrameData.layerHidden(), scale)
+
+		// Let image/png compress the frame, then take its chunks apart
+		framePNG := &bytes.Buffer{}
+		if err := png.Encode(framePNG, frameImage); err != nil {
+			return err
+		}
+		chunks, err := readPNGChunks(framePNG.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if frameN == 0 {
+			// Every frame shares the size and palette, so the first frame's header chunks describe them all
+			for _, chunk := range chunks {
+				switch chunk.chunkType {
+					case "IHDR":
+						if err := writePNGChunk(w, "IHDR", chunk.data); err != nil {
+							return err
+						}
+						animationControl := make([]byte, 8)
+						binary.BigEndian.PutUint32(animationControl[0:4], uint32(frameCount))
+						if !p.FrameData.Loop {
+							binary.BigEndian.PutUint32(animationControl[4:8], 1) // 0 plays forever
+						}
+						if err := writePNGChunk(w, "acTL", animationControl); err != nil {
+							return err
+						}
+					case "PLTE", "tRNS":
+						if err := writePNGChunk(w, chunk.chunkType, chunk.data); err != nil {
+							return err
+						}
+				}
+			}
+		}
+
+		frameControl := make([]byte, 26)
+		binary.BigEndian.PutUint32(frameControl[0:4], sequenceN)
+		binary.BigEndian.PutUint32(frameControl[4:8], uint32(frameImage.Bounds().Dx()))
+		binary.BigEndian.PutUint32(frameControl[8:12], uint32(frameImage.Bounds().Dy()))
+		// The x and y offsets stay 0
+		binary.BigEndian.PutUint16(frameControl[20:22], uint16(denominator)) // Delay numerator, in seconds
+		binary.BigEndian.PutUint16(frameControl[22:24], uint16(numerator)) // Delay denominator
+		// Dispose and blend ops stay APNG_DISPOSE_OP_NONE and APNG_BLEND_OP_SOURCE
+		if err := writePNGChunk(w, "fcTL", frameControl); err != nil {
+			return err
+		}
+		sequenceN++
+
+		for _, chunk := range chunks {
+			if chunk.chunkType != "IDAT" {
+				continue
+			}
+			if frameN == 0 {
+				err = writePNGChunk(w, "IDAT", chunk.data)
+			} else {
+				frameData := make([]byte, 4, 4 + len(chunk.data))
+				binary.BigEndian.PutUint32(frameData, sequenceN)
+				err = writePNGChunk(w, "fdAT", append(frameData, chunk.data...))
+				sequenceN++
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
+
+type pngChunk struct {
+	chunkType string
+	data []byte
+}
+
+var errBadPNG = errors.New("malformed PNG chunk")
+
+func readPNGChunks(pngData []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(pngData, pngSignature) {
+		return nil, errBadPNG
+	}
+	pngData = pngData[len(pngSignature):]
+	chunks := make([]pngChunk, 0)
+	for len(pngData) >= 12 {
+		length := int(binary.BigEndian.Uint32(pngData[0:4]))
+		if len(pngData) < 12 + length {
+			return nil, errBadPNG
+		}
+		chunks = append(chunks, pngChunk{chunkType: string(pngData[4:8]), data: pngData[8:8 + length]})
+		pngData = pngData[12 + length:]
+	}
+	return chunks, nil
+}
+
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	chunk := make([]byte, 8, 12 + len(data))
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(data)))
+	copy(chunk[4:8], chunkType)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	_, err := w.Write(chunk)
+	return err
+}
@@ -0,0 +1,157 @@
+package ppm
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Track selects one of the four sound tracks of a flipnote
+type Track int
+const (
+	TrackBGM Track = iota
+	TrackSoundEffect1
+	TrackSoundEffect2
+	TrackSoundEffect3
+)
+
+// Track returns the PCM samples of the given track at SampleRate
+func (soundData *SoundData) Track(track Track) ([]int16, error) {
+	switch track {
+		case TrackBGM:
+			return soundData.BGM, nil
+		case TrackSoundEffect1:
+			return soundData.SoundEffect1, nil
+		case TrackSoundEffect2:
+			return soundData.SoundEffect2, nil
+		case TrackSoundEffect3:
+			return soundData.SoundEffect3, nil
+	}
+	return nil, ErrUnknownTrack
+}
+
+// WriteWAV writes a track as a RIFF/WAVE stream of 16-bit mono PCM at SampleRate
+func (soundData *SoundData) WriteWAV(track Track, w io.Writer) error {
+	pcm, err := soundData.Track(track)
+	if err != nil {
+		return err
+	}
+	return writeWAV(w, pcm)
+}
+
+func writeWAV(w io.Writer, pcm []int16) error {
+	dataSize := uint32(len(pcm) * 2)
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], 36 + dataSize)
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(header[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:24], 1) // Mono
+	binary.LittleEndian.PutUint32(header[24:28], SampleRate)
+	binary.LittleEndian.PutUint32(header[28:32], SampleRate * 2) // Byte rate
+	binary.LittleEndian.PutUint16(header[32:34], 2) // Block align
+	binary.LittleEndian.PutUint16(header[34:36], 16) // Bits per sample
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], dataSize)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	data := make([]byte, dataSize)
+	for i, sample := range pcm {
+		binary.LittleEndian.PutUint16(data[i * 2:], uint16(sample))
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// MixAudio lays the BGM and every triggered sound effect onto one PCM track at SampleRate, the
+// way the DSi plays them back: the BGM, adjusted to the frame speed, starts with the first frame, and each sound effect starts
+// with every frame that flags it. Overlapping sounds are summed and clamped to 16 bits.
+func (ppmData *PPM) MixAudio() ([]int16, error) {
+	soundData := &ppmData.SoundData
+	if soundData.SoundEffectFlags == nil {
+		return nil, ErrNoAudioData
+	}
+	timing := ppmData.Timing()
+	if !timing.Valid() {
+		return nil, ErrBadFrameSpeed
+	}
+	frameSample := timing.FrameToSample
+
+	bgm, err := soundData.PlaybackBGM()
+	if err != nil {
+		return nil, err
+	}
+
+	frameCount := len(soundData.SoundEffectFlags)
+	mixLength := frameSample(frameCount)
+	if len(bgm) > mixLength {
+		mixLength = len(bgm)
+	}
+	soundEffects := [3][]int16{soundData.SoundEffect1, soundData.SoundEffect2, soundData.SoundEffect3}
+	for frameN, soundEffectFlags := range soundData.SoundEffectFlags {
+		for soundEffect := 0; soundEffect < 3; soundEffect++ {
+			if soundEffectFlags[soundEffect] != 0 && frameSample(frameN) + len(soundEffects[soundEffect]) > mixLength {
+				mixLength = frameSample(frameN) + len(soundEffects[soundEffect])
+			}
+		}
+	}
+
+	mix := make([]int32, mixLength)
+	for i, sample := range bgm {
+		mix[i] += int32(sample)
+	}
+	for frameN, soundEffectFlags := range soundData.SoundEffectFlags {
+		for soundEffect := 0; soundEffect < 3; soundEffect++ {
+			if soundEffectFlags[soundEffect] == 0 {
+				continue
+			}
+			start := frameSample(frameN)
+			for i, sample := range soundEffects[soundEffect] {
+				mix[start + i] += int32(sample)
+			}
+		}
+	}
+
+	pcm := make([]int16, mixLength)
+	for i, sample := range mix {
+		if sample > 32767 { sample = 32767 }
+		if sample < -32768 { sample = -32768 }
+		pcm[i] = int16(sample)
+	}
+	return pcm, nil
+}
+
+// PlaybackBGM returns the BGM as it sounds when played back at the frame speed. The DSi plays
+// the BGM faster or slower when the frame speed differs from the speed it was recorded at, so
+// it is resampled by the ratio between the two frame rates.
+func (soundData *SoundData) PlaybackBGM() ([]int16, error) {
+	if soundData.SoundMeta.BGMSpeed == soundData.SoundMeta.FrameSpeed || len(soundData.BGM) == 0 {
+		return soundData.BGM, nil
+	}
+	timing := Timing{FrameSpeed: soundData.SoundMeta.FrameSpeed}
+	bgmTiming := Timing{FrameSpeed: soundData.SoundMeta.BGMSpeed}
+	if !timing.Valid() || !bgmTiming.Valid() {
+		return nil, ErrBadFrameSpeed
+	}
+	return resamplePCM(soundData.BGM, timing.FPS() / bgmTiming.FPS()), nil
+}
+
+// resamplePCM plays pcm back speed times faster using linear interpolation
+func resamplePCM(pcm []int16, speed float64) []int16 {
+	resampledLength := int(float64(len(pcm)) / speed)
+	resampled := make([]int16, resampledLength)
+	for i := range resampled {
+		position := float64(i) * speed
+		index := int(position)
+		fraction := position - float64(index)
+		sample := float64(pcm[index])
+		if index + 1 < len(pcm) {
+			sample += (float64(pcm[index + 1]) - sample) * fraction
+		}
+		resampled[i] = int16(sample)
+	}
+	return resampled
+}
@@ -0,0 +1,119 @@
+package ppm
+
+import (
+	"math"
+	"testing"
+)
+
+// zeroCrossingFrequency estimates the frequency of a tone from how often it crosses zero
+func zeroCrossingFrequency(pcm []int16) float64 {
+	crossings := 0
+	for i := 1; i < len(pcm); i++ {
+		if (pcm[i - 1] < 0) != (pcm[i] < 0) {
+			crossings++
+		}
+	}
+	return float64(crossings) / 2 / (float64(len(pcm)) / SampleRate)
+}
+
+// A BGM recorded at one frame speed and played at another is sped up or slowed down by the ratio of their frame rates
+func TestPlaybackBGM(t *testing.T) {
+	tone := make([]int16, SampleRate * 2)
+	for i := range tone {
+		tone[i] = int16(10000 * math.Sin(2 * math.Pi * 200 * float64(i) / SampleRate))
+	}
+
+	resampleCases := []struct {
+		name string
+		bgmSpeed int
+		frameSpeed int
+		wantFrequency float64
+		wantLength int
+	}{
+		{"unchanged", 6, 6, 200, SampleRate * 2},
+		{"6-to-12-fps", 5, 6, 400, SampleRate},
+		{"30-to-12-fps", 8, 6, 80, SampleRate * 5},
+		{"4-to-20-fps", 4, 7, 1000, SampleRate * 2 / 5},
+	}
+
+	for _, resampleCase := range resampleCases {
+		t.Run(resampleCase.name, func(t *testing.T) {
+			frame := fixtureFrame{keyframe: true, paper: PaperWhite, pens: [2]byte{1, 1}}
+			fixture := fixturePPM{frames: []fixtureFrame{frame}, tracks: [4][]byte{EncodeADPCM(tone)},
+				frameSpeed: resampleCase.frameSpeed, bgmSpeed: resampleCase.bgmSpeed}
+			ppmData, err := DecodeBytes(fixture.build())
+			if err != nil {
+				t.Fatal(err)
+			}
+			bgm, err := ppmData.SoundData.PlaybackBGM()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bgm) != resampleCase.wantLength {
+				t.Fatalf("%d samples, want %d", len(bgm), resampleCase.wantLength)
+			}
+			if frequency := zeroCrossingFrequency(bgm); math.Abs(frequency - resampleCase.wantFrequency) > resampleCase.wantFrequency / 100 {
+				t.Fatalf("tone plays at %.1f Hz, want %.1f Hz", frequency, resampleCase.wantFrequency)
+			}
+		})
+	}
+}
+
+// constantPCM returns length samples of value
+func constantPCM(value int16, length int) []int16 {
+	pcm := make([]int16, length)
+	for i := range pcm {
+		pcm[i] = value
+	}
+	return pcm
+}
+
+func TestMixAudio(t *testing.T) {
+	frame := fixtureFrame{keyframe: true, paper: PaperWhite, pens: [2]byte{1, 1}}
+	fixture := fixturePPM{frames: []fixtureFrame{frame, frame, frame},
+		soundFlags: []byte{0x1, 0x3, 0x4}, // SE1 on frame 0, SE1 and SE2 on frame 1, SE3 on the last frame
+		tracks: [4][]byte{{0}, {0}, {0}, {0}}}
+	ppmData, err := DecodeBytes(fixture.build())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Known sounds in place of the decoded ones, so every sample of the mix can be predicted
+	timing := ppmData.Timing()
+	bgm := constantPCM(-20000, 3000)
+	for i := 0; i < timing.FrameToSample(2); i++ {
+		bgm[i] = 0
+	}
+	ppmData.SoundData.BGM = bgm
+	ppmData.SoundData.SoundEffect1 = constantPCM(1000, 100)
+	ppmData.SoundData.SoundEffect2 = constantPCM(32000, 100)
+	ppmData.SoundData.SoundEffect3 = constantPCM(-20000, 2000) // Runs on well past the last frame and the BGM
+
+	mix, err := ppmData.MixAudio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantLength := timing.FrameToSample(2) + 2000; len(mix) != wantLength {
+		t.Fatalf("%d samples, want %d", len(mix), wantLength)
+	}
+	mixCases := []struct {
+		name string
+		sample int
+		want int16
+	}{
+		{"first-frame", timing.FrameToSample(0), 1000},
+		{"after-SE1", timing.FrameToSample(0) + 100, 0},
+		{"before-second-frame", timing.FrameToSample(1) - 1, 0},
+		{"clipped-high", timing.FrameToSample(1), 32767}, // 1000 + 32000
+		{"second-frame-end", timing.FrameToSample(1) + 100, 0},
+		{"before-last-frame", timing.FrameToSample(2) - 1, 0},
+		{"clipped-low", timing.FrameToSample(2), -32768}, // -20000 + -20000
+		{"after-BGM", 3000, -20000},
+		{"last-sample", len(mix) - 1, -20000},
+	}
+	for _, mixCase := range mixCases {
+		if mix[mixCase.sample] != mixCase.want {
+			t.Errorf("%s: sample %d = %d, want %d", mixCase.name, mixCase.sample, mix[mixCase.sample], mixCase.want)
+		}
+	}
+}
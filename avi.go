@@ -0,0 +1,259 @@
+package ppm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/jpeg"
+	"io"
+)
+
+type AVICodec int
+const (
+	AVICodecRaw AVICodec = iota // Uncompressed 24-bit RGB
+	AVICodecMJPEG
+)
+
+type AVIOptions struct {
+	Codec AVICodec
+	Scale int // Integer scale factor, 1 if unset
+	Quality int // JPEG quality for AVICodecMJPEG, 90 if unset
+}
+
+type aviIndexEntry struct {
+	chunkID string
+	offset uint32
+	size uint32
+}
+
+// aviWriter keeps track of where it is so chunk offsets and sizes can be patched afterwards
+type aviWriter struct {
+	w io.WriteSeeker
+	offset int64
+	err error
+}
+
+func (aviWriter *aviWriter) write(data []byte) {
+	if aviWriter.err != nil {
+		return
+	}
+	n, err := aviWriter.w.Write(data)
+	aviWriter.offset += int64(n)
+	aviWriter.err = err
+}
+
+func (aviWriter *aviWriter) writeFourCC(fourCC string) {
+	aviWriter.write([]byte(fourCC))
+}
+
+func (aviWriter *aviWriter) writeUint32(value uint32) {
+	aviWriter.write(binary.LittleEndian.AppendUint32(nil, value))
+}
+
+// writeChunk writes a whole chunk, padded to an even length
+func (aviWriter *aviWriter) writeChunk(fourCC string, data []byte) {
+	aviWriter.writeFourCC(fourCC)
+	aviWriter.writeUint32(uint32(len(data)))
+	aviWriter.write(data)
+	if len(data) % 2 != 0 {
+		aviWriter.write([]byte{0})
+	}
+}
+
+// startList writes a RIFF or LIST header and returns where its size needs to be patched
+func (aviWriter *aviWriter) startList(fourCC string, listType string) int64 {
+	aviWriter.writeFourCC(fourCC)
+	sizeOffset := aviWriter.offset
+	aviWriter.writeUint32(0)
+	aviWriter.writeFourCC(listType)
+	return sizeOffset
+}
+
+func (aviWriter *aviWriter) endList(sizeOffset int64) {
+	if aviWriter.err != nil {
+		return
+	}
+	endOffset := aviWriter.offset
+	if _, err := aviWriter.w.Seek(sizeOffset, io.SeekStart); err != nil {
+		aviWriter.err = err
+		return
+	}
+	aviWriter.offset = sizeOffset
+	aviWriter.writeUint32(uint32(endOffset - sizeOffset - 4))
+	if _, err := aviWriter.w.Seek(endOffset, io.SeekStart); err != nil && aviWriter.err == nil {
+		aviWriter.err = err
+	}
+	aviWriter.offset = endOffset
+}
+
+// EncodeAVI writes p as an AVI with one video stream at the flipnote's frame rate and, if the
+// sound was decoded, the mixed audio as an interleaved 16-bit PCM stream at SampleRate. The
+// output needs to be seekable so the list sizes can be filled in once everything is written.
+func EncodeAVI(w io.WriteSeeker, p *PPM, opts AVIOptions) error {
+	timing := p.Timing()
+	if !timing.Valid() {
+		return ErrBadFrameSpeed
+	}
+	frameCount := p.frameCount()
+	if frameCount == 0 {
+		return ErrNoFrameData
+	}
+	scale := opts.Scale
+	if scale < 1 {
+		scale = 1
+	}
+	quality := opts.Quality
+	if quality < 1 {
+		quality = 90
+	}
+	width := 256 * scale
+	height := 192 * scale
+	rawFrameSize := width * height * 3
+	numerator, denominator := timing.FrameRateFraction()
+
+	audio, err := p.MixAudio()
+	if err == ErrNoAudioData {
+		audio = nil
+	} else if err != nil {
+		return err
+	}
+	streamCount := uint32(1)
+	if audio != nil {
+		streamCount = 2
+	}
+
+	videoHandler := "DIB "
+	videoChunkID := "00db"
+	videoCompression := uint32(0) // BI_RGB
+	if opts.Codec == AVICodecMJPEG {
+		videoHandler = "MJPG"
+		videoChunkID = "00dc"
+		videoCompression = binary.LittleEndian.Uint32([]byte("MJPG"))
+	}
+
+	aviWriter := &aviWriter{w: w}
+	riffSize := aviWriter.startList("RIFF", "AVI ")
+
+	headerList := aviWriter.startList("LIST", "hdrl")
+	mainHeader := make([]byte, 56)
+	binary.LittleEndian.PutUint32(mainHeader[0:], uint32(timing.FrameDuration().Microseconds()))
+	binary.LittleEndian.PutUint32(mainHeader[12:], 0x10 | 0x100) // AVIF_HASINDEX | AVIF_ISINTERLEAVED
+	binary.LittleEndian.PutUint32(mainHeader[16:], uint32(frameCount))
+	binary.LittleEndian.PutUint32(mainHeader[24:], streamCount)
+	binary.LittleEndian.PutUint32(mainHeader[28:], uint32(rawFrameSize))
+	binary.LittleEndian.PutUint32(mainHeader[32:], uint32(width))
+	binary.LittleEndian.PutUint32(mainHeader[36:], uint32(height))
+	aviWriter.writeChunk("avih", mainHeader)
+
+	videoList := aviWriter.startList("LIST", "strl")
+	videoHeader := make([]byte, 56)
+	copy(videoHeader[0:], "vids")
+	copy(videoHeader[4:], videoHandler)
+	binary.LittleEndian.PutUint32(videoHeader[20:], uint32(denominator)) // dwScale
+	binary.LittleEndian.PutUint32(videoHeader[24:], uint32(numerator)) // dwRate
+	binary.LittleEndian.PutUint32(videoHeader[32:], uint32(frameCount))
+	binary.LittleEndian.PutUint32(videoHeader[36:], uint32(rawFrameSize))
+	binary.LittleEndian.PutUint32(videoHeader[40:], 0xFFFFFFFF) // Default quality
+	binary.LittleEndian.PutUint16(videoHeader[52:], uint16(width))
+	binary.LittleEndian.PutUint16(videoHeader[54:], uint16(height))
+	aviWriter.writeChunk("strh", videoHeader)
+	videoFormat := make([]byte, 40) // BITMAPINFOHEADER
+	binary.LittleEndian.PutUint32(videoFormat[0:], 40)
+	binary.LittleEndian.PutUint32(videoFormat[4:], uint32(width))
+	binary.LittleEndian.PutUint32(videoFormat[8:], uint32(height)) // Positive, so rows are stored bottom-up
+	binary.LittleEndian.PutUint16(videoFormat[12:], 1)
+	binary.LittleEndian.PutUint16(videoFormat[14:], 24)
+	binary.LittleEndian.PutUint32(videoFormat[16:], videoCompression)
+	binary.LittleEndian.PutUint32(videoFormat[20:], uint32(rawFrameSize))
+	aviWriter.writeChunk("strf", videoFormat)
+	aviWriter.endList(videoList)
+
+	if audio != nil {
+		audioList := aviWriter.startList("LIST", "strl")
+		audioHeader := make([]byte, 56)
+		copy(audioHeader[0:], "auds")
+		binary.LittleEndian.PutUint32(audioHeader[20:], 1) // dwScale
+		binary.LittleEndian.PutUint32(audioHeader[24:], SampleRate) // dwRate
+		binary.LittleEndian.PutUint32(audioHeader[32:], uint32(len(audio)))
+		binary.LittleEndian.PutUint32(audioHeader[36:], uint32(timing.FrameToSample(1) * 2 + 2))
+		binary.LittleEndian.PutUint32(audioHeader[40:], 0xFFFFFFFF)
+		binary.LittleEndian.PutUint32(audioHeader[44:], 2) // dwSampleSize
+		aviWriter.writeChunk("strh", audioHeader)
+		audioFormat := make([]byte, 18) // WAVEFORMATEX
+		binary.LittleEndian.PutUint16(audioFormat[0:], 1) // PCM
+		binary.LittleEndian.PutUint16(audioFormat[2:], 1) // Mono
+		binary.LittleEndian.PutUint32(audioFormat[4:], SampleRate)
+		binary.LittleEndian.PutUint32(audioFormat[8:], SampleRate * 2)
+		binary.LittleEndian.PutUint16(audioFormat[12:], 2)
+		binary.LittleEndian.PutUint16(audioFormat[14:], 16)
+		aviWriter.writeChunk("strf", audioFormat)
+		aviWriter.endList(audioList)
+	}
+	aviWriter.endList(headerList)
+
+	movieList := aviWriter.startList("LIST", "movi")
+	moviOffset := aviWriter.offset - 4 // Index offsets are relative to the movi fourCC
+	index := make([]aviIndexEntry, 0, frameCount * 2)
+	writeIndexedChunk := func(chunkID string, data []byte) {
+		index = append(index, aviIndexEntry{chunkID: chunkID, offset: uint32(aviWriter.offset - moviOffset), size: uint32(len(data))})
+		aviWriter.writeChunk(chunkID, data)
+	}
+
+	frameData := make([]byte, rawFrameSize)
+	for frameN := 0; frameN < frameCount; frameN++ {
+		frameLayers, err := p.FrameLayers(frameN)
+		if err != nil {
+			return err
+		}
+		frameImage := getFrameImage(frameLayers, p.FrameData.layerHidden(), scale)
+
+		if opts.Codec == AVICodecMJPEG {
+			jpegData := &bytes.Buffer{}
+			if err := jpeg.Encode(jpegData, frameImage, &jpeg.Options{Quality: quality}); err != nil {
+				return err
+			}
+			writeIndexedChunk(videoChunkID, jpegData.Bytes())
+		} else {
+			for y := 0; y < height; y++ {
+				row := frameData[(height - 1 - y) * width * 3:]
+				for x := 0; x < width; x++ {
+					pixelColor := framePaletteColors[frameImage.ColorIndexAt(x, y)].(color.RGBA)
+					row[x * 3] = pixelColor.B
+					row[x * 3 + 1] = pixelColor.G
+					row[x * 3 + 2] = pixelColor.R
+				}
+			}
+			writeIndexedChunk(videoChunkID, frameData)
+		}
+
+		if audio != nil {
+			audioStart := timing.FrameToSample(frameN)
+			audioEnd := timing.FrameToSample(frameN + 1)
+			if frameN == frameCount - 1 {
+				audioEnd = len(audio) // Anything still playing after the last frame goes with it
+			}
+			if audioStart < len(audio) && audioStart < audioEnd {
+				if audioEnd > len(audio) {
+					audioEnd = len(audio)
+				}
+				audioData := make([]byte, (audioEnd - audioStart) * 2)
+				for i, sample := range audio[audioStart:audioEnd] {
+					binary.LittleEndian.PutUint16(audioData[i * 2:], uint16(sample))
+				}
+				writeIndexedChunk("01wb", audioData)
+			}
+		}
+	}
+	aviWriter.endList(movieList)
+
+	indexData := make([]byte, 0, len(index) * 16)
+	for _, entry := range index {
+		indexData = append(indexData, entry.chunkID...)
+		indexData = binary.LittleEndian.AppendUint32(indexData, 0x10) // AVIIF_KEYFRAME
+		indexData = binary.LittleEndian.AppendUint32(indexData, entry.offset)
+		indexData = binary.LittleEndian.AppendUint32(indexData, entry.size)
+	}
+	aviWriter.writeChunk("idx1", indexData)
+	aviWriter.endList(riffSize)
+	return aviWriter.err
+}
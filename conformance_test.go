@@ -0,0 +1,139 @@
+package ppm
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// conformanceCases cover every line type, keyframes and diffs, moved frames, both papers and every pen
+var conformanceCases = []struct {
+	name string
+	fixture fixturePPM
+}{
+	{"line-types", fixturePPM{frames: []fixtureFrame{{keyframe: true, paper: PaperWhite, pens: [2]byte{1, 2}, lines: [2]map[int]fixtureLine{
+		{10: chunkedLine(map[int]byte{0: 0xFF, 5: 0x81, 31: 0x0F}), 20: invertedLine(map[int]byte{3: 0x00, 4: 0xAA}), 30: rawLine(stripedChunks(0x5A)), 191: chunkedLine(map[int]byte{16: 0x01})},
+		{0: rawLine(stripedChunks(0xC3)), 11: chunkedLine(map[int]byte{1: 0xF0}), 100: invertedLine(map[int]byte{})},
+	}}}}},
+	{"black-paper", fixturePPM{frames: []fixtureFrame{{keyframe: true, paper: PaperBlack, pens: [2]byte{3, 0}, lines: [2]map[int]fixtureLine{
+		{50: invertedLine(map[int]byte{10: 0x0F}), 51: rawLine(stripedChunks(0x33))},
+		{52: chunkedLine(map[int]byte{8: 0xFF, 9: 0xFF}), 53: invertedLine(map[int]byte{0: 0x00})},
+	}}}}},
+	{"all-pens", fixturePPM{frames: []fixtureFrame{
+		{keyframe: true, paper: PaperWhite, pens: [2]byte{0, 1}, lines: boxLines(0, 40)},
+		{keyframe: true, paper: PaperWhite, pens: [2]byte{2, 3}, lines: boxLines(0, 40)},
+		{keyframe: true, paper: PaperBlack, pens: [2]byte{3, 2}, lines: boxLines(0, 40)},
+		{keyframe: true, paper: PaperBlack, pens: [2]byte{1, 0}, lines: boxLines(0, 40)},
+	}}},
+	{"diffs", fixturePPM{frames: []fixtureFrame{
+		{keyframe: true, paper: PaperWhite, pens: [2]byte{1, 2}, lines: boxLines(0, 60)},
+		{paper: PaperWhite, pens: [2]byte{1, 2}, lines: boxLines(30, 60)},
+		{paper: PaperWhite, pens: [2]byte{3, 1}, lines: [2]map[int]fixtureLine{{100: invertedLine(map[int]byte{})}, {}}},
+		{keyframe: true, paper: PaperBlack, pens: [2]byte{1, 3}, lines: boxLines(90, 20)},
+	}}},
+	{"translated", fixturePPM{frames: []fixtureFrame{
+		{keyframe: true, paper: PaperWhite, pens: [2]byte{1, 2}, lines: boxLines(80, 40)},
+		{translated: true, translateX: 20, translateY: -10, paper: PaperWhite, pens: [2]byte{1, 2}},
+		{translated: true, translateX: -90, translateY: 100, paper: PaperWhite, pens: [2]byte{1, 2}, lines: [2]map[int]fixtureLine{{0: rawLine(stripedChunks(0xFF))}, {}}},
+	}}},
+}
+
+// stripedChunks returns a raw line with every chunk set to chunkByte
+func stripedChunks(chunkByte byte) [32]byte {
+	chunks := [32]byte{}
+	for chunk := range chunks {
+		chunks[chunk] = chunkByte
+	}
+	return chunks
+}
+
+// boxLines draws a filled box on layer 1 and the box's outline on layer 2, both starting at line top
+func boxLines(top int, size int) [2]map[int]fixtureLine {
+	lines := [2]map[int]fixtureLine{{}, {}}
+	for line := top; line < top + size; line++ {
+		filled := map[int]byte{}
+		for chunk := 4; chunk < 4 + size / 8; chunk++ {
+			filled[chunk] = 0xFF
+		}
+		lines[0][line] = chunkedLine(filled)
+		if line == top || line == top + size - 1 {
+			lines[1][line] = chunkedLine(filled)
+		} else {
+			lines[1][line] = chunkedLine(map[int]byte{4: 0x01, 3 + size / 8: 0x80})
+		}
+	}
+	return lines
+}
+
+func TestConformance(t *testing.T) {
+	for _, conformanceCase := range conformanceCases {
+		t.Run(conformanceCase.name, func(t *testing.T) {
+			ppmData, err := DecodeBytes(conformanceCase.fixture.build())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ppmData.FrameCount != len(conformanceCase.fixture.frames) {
+				t.Fatalf("FrameCount = %d, want %d", ppmData.FrameCount, len(conformanceCase.fixture.frames))
+			}
+			if ppmData.AuthorName != "Fix" || ppmData.OriginalAuthorID != fixtureAuthorID || ppmData.FileName != fixtureFileName {
+				t.Fatalf("header decoded as %q, %q, %q", ppmData.AuthorName, ppmData.OriginalAuthorID, ppmData.FileName)
+			}
+			for frameN := range conformanceCase.fixture.frames {
+				frameLayers, err := ppmData.FrameLayers(frameN)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkGolden(t, fmt.Sprintf("%s-%d-layers.png", conformanceCase.name, frameN), layersImage(frameLayers))
+				checkGolden(t, fmt.Sprintf("%s-%d.png", conformanceCase.name, frameN), ppmData.FrameData.Frames[frameN].FrameImage)
+			}
+		})
+	}
+}
+
+// checkGolden compares img with a PNG in testdata/golden, pixel by pixel, or rewrites it with -update
+func checkGolden(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	goldenPath := filepath.Join("testdata", "golden", name)
+	if *updateGolden {
+		pngBuffer := &bytes.Buffer{}
+		if err := png.Encode(pngBuffer, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(goldenPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(goldenPath, pngBuffer.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	goldenFile, err := os.Open(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer goldenFile.Close()
+	golden, err := png.Decode(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if golden.Bounds() != img.Bounds() {
+		t.Fatalf("%s: bounds %v, want %v", name, img.Bounds(), golden.Bounds())
+	}
+	for y := golden.Bounds().Min.Y; y < golden.Bounds().Max.Y; y++ {
+		for x := golden.Bounds().Min.X; x < golden.Bounds().Max.X; x++ {
+			gotR, gotG, gotB, gotA := img.At(x, y).RGBA()
+			wantR, wantG, wantB, wantA := golden.At(x, y).RGBA()
+			if gotR != wantR || gotG != wantG || gotB != wantB || gotA != wantA {
+				t.Fatalf("%s: pixel (%d, %d) differs from the golden file", name, x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package ppm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Encode writes the flipnote as a PPM file. Frames come from FrameData.Frames[n].Layers where set,
+// otherwise from the decoded frame data, and the sound tracks are re-encoded from SoundData.
+// The signature is left zeroed, so the file plays back but won't verify on a DSi.
+//
+// A flipnote decoded with OpenConfig skipping sound or header fields returns ErrNoAudioData or
+// ErrNoHeaderData rather than writing them out blank; set OpenConfig to nil to encode it anyway.
+func (ppmData *PPM) Encode(w io.Writer) error {
+	if err := ppmData.OpenConfig.skippedFields(); err != nil {
+		return err
+	}
+	frameCount := ppmData.frameCount()
+	if frameCount == 0 {
+		return ErrNoFrameData
+	}
+	if frameCount > 999 {
+		return ErrFrameCount
+	}
+	soundMeta := ppmData.SoundData.SoundMeta
+	if !(Timing{FrameSpeed: soundMeta.FrameSpeed}).Valid() {
+		return ErrBadFrameSpeed
+	}
+	bgmSpeed := soundMeta.BGMSpeed
+	if !(Timing{FrameSpeed: bgmSpeed}).Valid() {
+		bgmSpeed = soundMeta.FrameSpeed // Nothing was recorded, so the BGM plays at the frame speed
+	}
+
+	animationData, err := ppmData.encodeAnimation(frameCount)
+	if err != nil {
+		return err
+	}
+
+	tracks := [4][]byte{}
+	for track := TrackBGM; track <= TrackSoundEffect3; track++ {
+		tracks[track] = ppmData.SoundData.encodeTrack(track)
+	}
+	audioSize := 0
+	for _, track := range tracks {
+		audioSize += len(track)
+	}
+
+	header, err := ppmData.Meta.encode(len(animationData), audioSize, frameCount)
+	if err != nil {
+		return err
+	}
+
+	ppmBuffer := bytes.NewBuffer(header)
+	ppmBuffer.Write(animationData)
+
+	// One byte of sound effect flags per frame, padded to 4 bytes before the sound header
+	soundFlags := make([]byte, frameCount)
+	for frameN := 0; frameN < frameCount && frameN < len(ppmData.SoundData.SoundEffectFlags); frameN++ {
+		frameFlags := ppmData.SoundData.SoundEffectFlags[frameN]
+		for track := 0; track < 3; track++ {
+			if frameFlags[track] > 0 {
+				soundFlags[frameN] |= 1 << uint(track)
+			}
+		}
+	}
+	ppmBuffer.Write(soundFlags)
+	for ppmBuffer.Len() % 4 != 0 {
+		ppmBuffer.WriteByte(0)
+	}
+
+	soundHeader := make([]byte, 32)
+	for track := 0; track < 4; track++ {
+		binary.LittleEndian.PutUint32(soundHeader[track * 4:], uint32(len(tracks[track])))
+	}
+	soundHeader[16] = byte(8 - soundMeta.FrameSpeed)
+	soundHeader[17] = byte(8 - bgmSpeed)
+	ppmBuffer.Write(soundHeader)
+	for _, track := range tracks {
+		ppmBuffer.Write(track)
+	}
+
+	ppmBuffer.Write(make([]byte, 0x80 + 0x10)) // Signature and padding
+
+	_, err = w.Write(ppmBuffer.Bytes())
+	return err
+}
+
+// MarshalBinary encodes the flipnote as a PPM file, as Encode does.
+func (ppmData *PPM) MarshalBinary() ([]byte, error) {
+	ppmBuffer := &bytes.Buffer{}
+	if err := ppmData.Encode(ppmBuffer); err != nil {
+		return nil, err
+	}
+	return ppmBuffer.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a PPM file into ppmData, replacing its contents but keeping its OpenConfig.
+// data is copied, since frames are decoded from it on demand.
+func (ppmData *PPM) UnmarshalBinary(data []byte) error {
+	*ppmData = PPM{OpenConfig: ppmData.OpenConfig}
+	ppmFile := append([]byte{}, data...)
+	return ppmData.decode(bytes.NewReader(ppmFile), int64(len(ppmFile)))
+}
+
+// skippedFields returns the error Encode gives for a flipnote decoded without some of what it writes.
+// The sizes and frame count are worked out again, and the checks don't change what was decoded.
+func (openConfig *OpenConfig) skippedFields() error {
+	if openConfig == nil {
+		return nil
+	}
+	if openConfig.SkipFrameData {
+		return ErrNoFrameData
+	}
+	if openConfig.SkipAudioData {
+		return ErrNoAudioData
+	}
+	if openConfig.SkipAuthorName || openConfig.SkipDate || openConfig.SkipFileName ||
+		openConfig.SkipLastEditedAuthorID || openConfig.SkipLastEditedAuthorName || openConfig.SkipLockStatus ||
+		openConfig.SkipOriginalAuthorID || openConfig.SkipOriginalAuthorName || openConfig.SkipOriginalFileName ||
+		openConfig.SkipPartialFileName || openConfig.SkipPreviewFrameN || openConfig.SkipPreviousEditingAuthorID ||
+		openConfig.SkipThumbnail {
+		return ErrNoHeaderData
+	}
+	return nil
+}
+
+// encodeTrack returns the ADPCM data of a track. A track that still holds the samples it was decoded
+// to keeps its original ADPCM data, since re-encoding clipped samples isn't always exact.
+func (soundData *SoundData) encodeTrack(track Track) []byte {
+	pcm, _ := soundData.Track(track)
+	if storedADPCM := soundData.adpcm[track]; storedADPCM != nil {
+		storedPCM := DecodeADPCM(storedADPCM)
+		unchanged := len(storedPCM) == len(pcm)
+		for i := 0; unchanged && i < len(pcm); i++ {
+			unchanged = storedPCM[i] == pcm[i]
+		}
+		if unchanged {
+			return storedADPCM
+		}
+	}
+	return EncodeADPCM(pcm)
+}
+
+// encodeAnimation builds the animation section: the offset table header, the offset table and every frame
+func (ppmData *PPM) encodeAnimation(frameCount int) ([]byte, error) {
+	offsetTableLength := frameCount * 4
+	animationHeader := make([]byte, 8 + offsetTableLength)
+	binary.LittleEndian.PutUint16(animationHeader[0:], uint16(offsetTableLength))
+	animationFlags := uint16(0)
+	if ppmData.FrameData.Loop {
+		animationFlags |= 0x2
+	}
+	if ppmData.FrameData.Layer1Hidden {
+		animationFlags |= 0x10
+	}
+	if ppmData.FrameData.Layer2Hidden {
+		animationFlags |= 0x20
+	}
+	binary.LittleEndian.PutUint16(animationHeader[6:], animationFlags)
+
+	frameBuffer := &bytes.Buffer{}
+	var prevInk *[2][192][32]byte
+	for frameN := 0; frameN < frameCount; frameN++ {
+		frameLayers, err := ppmData.unpackFrame(frameN)
+		if err != nil {
+			return nil, err
+		}
+		ink := packLayers(frameLayers)
+		binary.LittleEndian.PutUint32(animationHeader[8 + frameN * 4:], uint32(frameBuffer.Len()))
+		frameBuffer.Write(encodeFrame(frameLayers, ink, prevInk))
+		prevInk = ink
+	}
+	for (len(animationHeader) + frameBuffer.Len()) % 4 != 0 {
+		frameBuffer.WriteByte(0)
+	}
+	return append(animationHeader, frameBuffer.Bytes()...), nil
+}
+
+// encode builds the 0x6A0 byte header, including the thumbnail if there is one
+func (meta *Meta) encode(animationSize int, audioSize int, frameCount int) ([]byte, error) {
+	header := make([]byte, metaSize)
+	copy(header[0x0:], ppmMagic)
+	binary.LittleEndian.PutUint32(header[0x4:], uint32(animationSize))
+	binary.LittleEndian.PutUint32(header[0x8:], uint32(audioSize))
+	binary.LittleEndian.PutUint16(header[0xC:], uint16(frameCount - 1))
+	binary.LittleEndian.PutUint16(header[0xE:], 0x24)
+	if meta.Locked {
+		binary.LittleEndian.PutUint16(header[0x10:], 1)
+	}
+	binary.LittleEndian.PutUint16(header[0x12:], uint16(meta.PreviewFrame))
+
+	encodeAuthorName(header[0x14:0x2A], meta.OriginalAuthorName)
+	encodeAuthorName(header[0x2A:0x40], meta.LastEditedAuthorName)
+	encodeAuthorName(header[0x40:0x56], meta.AuthorName)
+
+	authorIDs := []struct {
+		field string
+		offset int
+		id string
+	}{{"OriginalAuthorID", 0x56, meta.OriginalAuthorID},
+		{"LastEditedAuthorID", 0x5E, meta.LastEditedAuthorID},
+		{"PreviousEditingAuthorID", 0x8A, meta.PreviousEditingAuthorID}}
+	for _, authorID := range authorIDs {
+		if err := encodeAuthorID(header[authorID.offset:authorID.offset + 8], authorID.id); err != nil {
+			return nil, &FormatError{Field: authorID.field, Offset: int64(authorID.offset), Err: err}
+		}
+	}
+
+	if err := encodeFileName(header[0x66:0x78], meta.OriginalFileName); err != nil {
+		return nil, &FormatError{Field: "OriginalFileName", Offset: 0x66, Err: err}
+	}
+	if err := encodeFileName(header[0x78:0x8A], meta.FileName); err != nil {
+		return nil, &FormatError{Field: "FileName", Offset: 0x78, Err: err}
+	}
+
+	copy(header[0x92:0x9A], meta.PartialFileName)
+	if meta.Date > 946684800 {
+		binary.LittleEndian.PutUint32(header[0x9A:], uint32(meta.Date - 946684800)) // Seconds since 2000-01-01
+	}
+
+	copy(header[0xA0:metaSize], meta.PreviewFrameBitmap)
+	return header, nil
+}
+
+// encodeAuthorName writes name as zero-padded UTF-16LE, cut down to fit nameBytes
+func encodeAuthorName(nameBytes []byte, name string) {
+	nameChars := utf16.Encode([]rune(name))
+	for i := 0; i < len(nameChars) && i * 2 + 1 < len(nameBytes); i++ {
+		binary.LittleEndian.PutUint16(nameBytes[i * 2:], nameChars[i])
+	}
+}
+
+// encodeAuthorID writes an author ID as its 8 bytes in little endian order
+func encodeAuthorID(idBytes []byte, id string) error {
+	if match, _ := regexp.MatchString("^" + regexID + "$", strings.ToUpper(id)); !match {
+		return ErrInvalidAuthorID
+	}
+	decodedID, _ := hex.DecodeString(id)
+	copy(idBytes, binaryReadLE(decodedID))
+	return nil
+}
+
+// encodeFileName writes a file name as 3 bytes of MAC address, 13 characters and an edit counter
+func encodeFileName(fileNameBytes []byte, fileName string) error {
+	fileName = strings.ToUpper(fileName)
+	if match, _ := regexp.MatchString("^" + regexFileName + "$", fileName); !match {
+		return ErrInvalidFileName
+	}
+	fileNameParts := strings.Split(fileName, "_")
+	macBytes, _ := hex.DecodeString(fileNameParts[0])
+	editCounter, _ := strconv.Atoi(fileNameParts[2])
+	copy(fileNameBytes[0:3], macBytes)
+	copy(fileNameBytes[3:16], fileNameParts[1])
+	binary.LittleEndian.PutUint16(fileNameBytes[16:18], uint16(editCounter))
+	return nil
+}
@@ -0,0 +1,183 @@
+package ppm
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomFixture has keyframes, diffs and moved diffs of random lines of every type, and random sound
+func randomFixture(seed int64) fixturePPM {
+	random := rand.New(rand.NewSource(seed))
+	frames := make([]fixtureFrame, 12)
+	for frameN := range frames {
+		frame := fixtureFrame{keyframe: frameN % 5 == 0, paper: PaperColor(random.Intn(2)), pens: [2]byte{byte(1 + random.Intn(3)), byte(1 + random.Intn(3))}}
+		if frameN % 3 == 1 {
+			frame.translated = true
+			frame.translateX, frame.translateY = int8(random.Intn(41) - 20), int8(random.Intn(41) - 20)
+		}
+		frame.lines = [2]map[int]fixtureLine{{}, {}}
+		for layer := 0; layer < 2; layer++ {
+			for lineN := 0; lineN < 40; lineN++ {
+				chunks := map[int]byte{}
+				for chunk := random.Intn(6); chunk > 0; chunk-- {
+					chunks[random.Intn(32)] = byte(random.Intn(256))
+				}
+				rawChunks := [32]byte{}
+				random.Read(rawChunks[:])
+				line := []fixtureLine{chunkedLine(chunks), invertedLine(chunks), rawLine(rawChunks)}[random.Intn(3)]
+				frame.lines[layer][random.Intn(192)] = line
+			}
+		}
+		frames[frameN] = frame
+	}
+
+	soundFlags := make([]byte, len(frames))
+	for frameN := range soundFlags {
+		soundFlags[frameN] = byte(random.Intn(8))
+	}
+	tracks := [4][]byte{}
+	for track := range tracks {
+		tracks[track] = make([]byte, 64 + random.Intn(256))
+		random.Read(tracks[track])
+	}
+	return fixturePPM{frames: frames, animationFlags: 0x12, soundFlags: soundFlags, tracks: tracks, frameSpeed: 5, bgmSpeed: 3, previewFrame: 7}
+}
+
+// roundTrip encodes ppmData and decodes the result
+func roundTrip(t *testing.T, ppmData *PPM) *PPM {
+	t.Helper()
+	encoded, err := ppmData.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeBytes(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// checkSameFlipnote fails unless both flipnotes hold the same header, frames and sound.
+// The animation size is left out, since the encoder may pick different line types.
+func checkSameFlipnote(t *testing.T, want *PPM, got *PPM) {
+	t.Helper()
+	wantMeta, gotMeta := want.Meta, got.Meta
+	wantMeta.AnimationSize, gotMeta.AnimationSize = 0, 0
+	wantMeta.PreviewFrameImage, gotMeta.PreviewFrameImage = nil, nil
+	if !reflect.DeepEqual(wantMeta, gotMeta) {
+		t.Fatalf("header decoded as\n%+v\nwant\n%+v", gotMeta, wantMeta)
+	}
+	if want.FrameData.Loop != got.FrameData.Loop || want.FrameData.Layer1Hidden != got.FrameData.Layer1Hidden || want.FrameData.Layer2Hidden != got.FrameData.Layer2Hidden {
+		t.Fatalf("animation flags decoded as %+v", got.FrameData)
+	}
+	for frameN := 0; frameN < want.FrameCount; frameN++ {
+		wantLayers, err := want.FrameLayers(frameN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotLayers, err := got.FrameLayers(frameN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wantLayers.Layers != gotLayers.Layers || wantLayers.PaperColor != gotLayers.PaperColor || wantLayers.PenColor != gotLayers.PenColor {
+			t.Fatalf("frame %d differs", frameN)
+		}
+	}
+	if want.SoundData.SoundMeta.FrameSpeed != got.SoundData.SoundMeta.FrameSpeed || want.SoundData.SoundMeta.BGMSpeed != got.SoundData.SoundMeta.BGMSpeed {
+		t.Fatalf("speeds decoded as %+v", got.SoundData.SoundMeta)
+	}
+	for track := TrackBGM; track <= TrackSoundEffect3; track++ {
+		if !reflect.DeepEqual(want.SoundData.adpcm[track], got.SoundData.adpcm[track]) {
+			t.Fatalf("track %d differs", track)
+		}
+	}
+	if !reflect.DeepEqual(want.SoundData.SoundEffectFlags, got.SoundData.SoundEffectFlags) {
+		t.Fatal("sound effect flags differ")
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	roundTripCases := []struct {
+		name string
+		ppmBytes []byte
+	}{
+		{"random", randomFixture(7).build()},
+		{"options", optionsFixture()},
+	}
+	for _, conformanceCase := range conformanceCases {
+		roundTripCases = append(roundTripCases, struct {
+			name string
+			ppmBytes []byte
+		}{conformanceCase.name, conformanceCase.fixture.build()})
+	}
+
+	for _, roundTripCase := range roundTripCases {
+		t.Run(roundTripCase.name, func(t *testing.T) {
+			ppmBytes := append([]byte{}, roundTripCase.ppmBytes...)
+			for i := 0; i < 1536; i++ {
+				ppmBytes[0xA0 + i] = byte(i * 7) // A thumbnail using every colour
+			}
+			ppmData, err := DecodeBytes(ppmBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSameFlipnote(t, ppmData, roundTrip(t, ppmData))
+		})
+	}
+}
+
+// The preview frame is a 16-bit field, so frames past 255 must survive
+func TestPreviewFrameRoundTrip(t *testing.T) {
+	frames := make([]image.Image, 300)
+	for frameN := range frames {
+		frameImage := image.NewGray(image.Rect(0, 0, 256, 192))
+		for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
+			frameImage.SetGray(pixelPosition, frameN % 192, color.Gray{255})
+		}
+		frames[frameN] = frameImage
+	}
+	ppmData, err := FromImages(frames, ImportOptions{PreviewFrame: 290, AuthorName: "Fix", FileName: fixtureFileName, AuthorID: fixtureAuthorID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := roundTrip(t, ppmData)
+	if decoded.PreviewFrame != 290 || decoded.FrameCount != 300 {
+		t.Fatalf("PreviewFrame = %d, FrameCount = %d", decoded.PreviewFrame, decoded.FrameCount)
+	}
+	if !reflect.DeepEqual(decoded.PreviewFrameBitmap, ppmData.PreviewFrameBitmap) {
+		t.Fatal("thumbnail differs")
+	}
+	checkSameFlipnote(t, decoded, roundTrip(t, decoded))
+}
+
+// UnmarshalBinary keeps its own copy of data, so frames decoded later don't see the caller's buffer change
+func TestUnmarshalBinaryCopies(t *testing.T) {
+	defer func(cacheSize int) { FrameCacheSize = cacheSize }(FrameCacheSize)
+	FrameCacheSize = 1
+
+	ppmBytes := randomFixture(13).build()
+	ppmData := &PPM{}
+	if err := ppmData.UnmarshalBinary(ppmBytes); err != nil {
+		t.Fatal(err)
+	}
+	want, err := ppmData.FrameLayers(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ppmData.FrameLayers(3); err != nil { // Evicts frame 0 from the cache
+		t.Fatal(err)
+	}
+	for i := metaSize; i < len(ppmBytes); i++ {
+		ppmBytes[i] = 0xFF
+	}
+	got, err := ppmData.FrameLayers(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Layers != want.Layers {
+		t.Fatal("frame 0 changed along with the caller's buffer")
+	}
+}
@@ -0,0 +1,66 @@
+package ppm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrBadMagic = errors.New("PPM magic incorrect")
+	ErrTruncated = errors.New("PPM data is truncated")
+	ErrInvalidAuthorID = errors.New("author ID is not valid")
+	ErrInvalidFileName = errors.New("file name is not valid")
+	ErrBadOffsetTable = errors.New("frame offset table length does not match the frame count or animation size")
+	ErrBadFrameOffset = errors.New("frame offset is out of range")
+	ErrBadTrackOffset = errors.New("sound track is out of range")
+
+	ErrNoFrameData = errors.New("frame data was not decoded")
+	ErrFrameIndex = errors.New("frame index is out of range")
+	ErrUnknownTrack = errors.New("unknown sound track")
+	ErrNoAudioData = errors.New("sound data was not decoded")
+	ErrNoHeaderData = errors.New("header fields were not decoded")
+	ErrBadFrameSpeed = errors.New("frame speed is out of range")
+	ErrFrameCount = errors.New("a PPM holds between 1 and 999 frames")
+	ErrSpriteSheetWidth = errors.New("sprite sheet MaxWidth is narrower than one frame")
+)
+
+// FormatError describes a field of a PPM that could not be decoded and where it was found.
+// Err is one of the Err* sentinels or the error returned by the underlying reader.
+type FormatError struct {
+	Field string
+	Offset int64
+	Err error
+}
+
+func (formatError *FormatError) Error() string {
+	return fmt.Sprintf("ppm: %s at offset 0x%X: %v", formatError.Field, formatError.Offset, formatError.Err)
+}
+
+func (formatError *FormatError) Unwrap() error {
+	return formatError.Err
+}
+
+// readAt reads exactly length bytes of field from offset, reporting short reads as ErrTruncated
+func readAt(ppmReader *io.SectionReader, field string, offset int64, length int) ([]byte, error) {
+	buffer := make([]byte, length)
+	n, err := ppmReader.ReadAt(buffer, offset)
+	if n < length {
+		if err == nil || err == io.EOF {
+			err = ErrTruncated
+		}
+		return nil, &FormatError{Field: field, Offset: offset + int64(n), Err: err}
+	}
+	return buffer, nil
+}
+
+// readNext reads exactly length bytes of field from the current position of ppmReader
+func readNext(ppmReader *io.SectionReader, field string, length int) ([]byte, error) {
+	offset, _ := ppmReader.Seek(0, io.SeekCurrent)
+	buffer, err := readAt(ppmReader, field, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	ppmReader.Seek(int64(length), io.SeekCurrent)
+	return buffer, nil
+}
@@ -0,0 +1,49 @@
+package ppm
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestFormatErrors(t *testing.T) {
+	errorCases := []struct {
+		name string
+		corrupt func(ppmBytes []byte) []byte
+		want error
+		field string
+		offset int64
+	}{
+		{"truncated-header", func(ppmBytes []byte) []byte { return ppmBytes[:0x50] }, ErrTruncated, "AuthorName", 0x50},
+		{"truncated-offsets", func(ppmBytes []byte) []byte { return ppmBytes[:0x6AA] }, ErrTruncated, "FrameOffsets", 0x6AA},
+		{"frame-offset", func(ppmBytes []byte) []byte {
+			binary.LittleEndian.PutUint32(ppmBytes[0x6AC:], 0xFFFFFF)
+			return ppmBytes
+		}, ErrBadFrameOffset, "FrameOffsets", 0x6AC},
+		{"offset-table-short", func(ppmBytes []byte) []byte {
+			binary.LittleEndian.PutUint16(ppmBytes[0x6A0:], 4)
+			return ppmBytes
+		}, ErrBadOffsetTable, "OffsetTableLength", 0x6A0},
+		// 0x6A8 plus this wraps around in 16 bits, which once pointed the frames back into the header
+		{"offset-table-long", func(ppmBytes []byte) []byte {
+			binary.LittleEndian.PutUint16(ppmBytes[0x6A0:], 0xF960)
+			return ppmBytes
+		}, ErrBadOffsetTable, "OffsetTableLength", 0x6A0},
+	}
+
+	for _, errorCase := range errorCases {
+		t.Run(errorCase.name, func(t *testing.T) {
+			_, err := DecodeBytes(errorCase.corrupt(optionsFixture()))
+			if !errors.Is(err, errorCase.want) {
+				t.Fatalf("error %v, want %v", err, errorCase.want)
+			}
+			var formatError *FormatError
+			if !errors.As(err, &formatError) {
+				t.Fatalf("error %v is not a FormatError", err)
+			}
+			if formatError.Field != errorCase.field || formatError.Offset != errorCase.offset {
+				t.Fatalf("error in %s at 0x%X, want %s at 0x%X", formatError.Field, formatError.Offset, errorCase.field, errorCase.offset)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package ppm
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+)
+
+// The fixtures below build small, valid PPM files in memory, so the decoder can be tested
+// without shipping real flipnotes.
+
+// fixtureLine is one line of a layer: its line type and the bytes stored for it
+type fixtureLine struct {
+	lineType int
+	data []byte
+}
+
+// chunkedLine builds a type 1 line holding the given chunks, keyed by chunk index
+func chunkedLine(chunks map[int]byte) fixtureLine {
+	return fixtureLine{lineType: 1, data: maskedChunks(chunks)}
+}
+
+// invertedLine builds a type 2 line: all ink, except for the given chunks
+func invertedLine(chunks map[int]byte) fixtureLine {
+	return fixtureLine{lineType: 2, data: maskedChunks(chunks)}
+}
+
+// rawLine builds a type 3 line out of 32 chunk bytes
+func rawLine(chunks [32]byte) fixtureLine {
+	return fixtureLine{lineType: 3, data: append([]byte{}, chunks[:]...)}
+}
+
+func maskedChunks(chunks map[int]byte) []byte {
+	chunkMask := uint32(0)
+	for chunk := range chunks {
+		chunkMask |= 0x80000000 >> uint(chunk)
+	}
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, chunkMask)
+	for chunk := 0; chunk < 32; chunk++ {
+		if chunkByte, ok := chunks[chunk]; ok {
+			data = append(data, chunkByte)
+		}
+	}
+	return data
+}
+
+// fixtureFrame is one frame: its header fields and the lines of both layers, keyed by line number
+type fixtureFrame struct {
+	keyframe bool
+	paper PaperColor
+	pens [2]byte // Raw pen values, so 0 can be tested as well
+	translateX int8
+	translateY int8
+	translated bool
+	lines [2]map[int]fixtureLine
+}
+
+func (frame fixtureFrame) bytes() []byte {
+	frameHeader := byte(frame.paper) | frame.pens[0] << 1 | frame.pens[1] << 3
+	if frame.keyframe {
+		frameHeader |= 0x80
+	}
+	frameBytes := []byte{frameHeader}
+	if frame.translated {
+		frameBytes[0] |= 0x20
+		frameBytes = append(frameBytes, byte(frame.translateX), byte(frame.translateY))
+	}
+
+	lineEncodings := make([]byte, 96)
+	lineData := []byte{}
+	for layer := 0; layer < 2; layer++ {
+		for line := 0; line < 192; line++ {
+			fixture, ok := frame.lines[layer][line]
+			if !ok {
+				continue
+			}
+			lineEncodings[layer * 48 + line / 4] |= byte(fixture.lineType) << uint((line % 4) * 2)
+			lineData = append(lineData, fixture.data...)
+		}
+	}
+	frameBytes = append(frameBytes, lineEncodings...)
+	return append(frameBytes, lineData...)
+}
+
+// fixturePPM is a whole flipnote. Unset frame speeds default to 6 and the BGM speed to the frame speed.
+type fixturePPM struct {
+	frames []fixtureFrame
+	animationFlags uint16
+	soundFlags []byte
+	tracks [4][]byte
+	frameSpeed int
+	bgmSpeed int
+	previewFrame int
+}
+
+const (
+	fixtureAuthorID = "5AB1234012345678"
+	fixtureFileName = "F78DA8_14FBF5B16B2A0_007"
+)
+
+func (fixture fixturePPM) build() []byte {
+	frameCount := len(fixture.frames)
+	ppmBytes := make([]byte, metaSize)
+	copy(ppmBytes, ppmMagic)
+	binary.LittleEndian.PutUint16(ppmBytes[0xC:], uint16(frameCount - 1))
+	binary.LittleEndian.PutUint16(ppmBytes[0xE:], 0x24)
+	binary.LittleEndian.PutUint16(ppmBytes[0x12:], uint16(fixture.previewFrame))
+	for _, nameOffset := range []int{0x14, 0x2A, 0x40} {
+		copy(ppmBytes[nameOffset:], []byte{'F', 0, 'i', 0, 'x', 0})
+	}
+	for _, idOffset := range []int{0x56, 0x5E, 0x8A} {
+		binary.LittleEndian.PutUint64(ppmBytes[idOffset:], 0x5AB1234012345678)
+	}
+	for _, fileNameOffset := range []int{0x66, 0x78} {
+		copy(ppmBytes[fileNameOffset:], []byte{0xF7, 0x8D, 0xA8})
+		copy(ppmBytes[fileNameOffset + 3:], "14FBF5B16B2A0")
+		binary.LittleEndian.PutUint16(ppmBytes[fileNameOffset + 16:], 7)
+	}
+	binary.LittleEndian.PutUint32(ppmBytes[0x9A:], 0x12345678)
+
+	animation := make([]byte, 8 + frameCount * 4)
+	binary.LittleEndian.PutUint16(animation[0:], uint16(frameCount * 4))
+	binary.LittleEndian.PutUint16(animation[6:], fixture.animationFlags)
+	frameData := []byte{}
+	for frameN, frame := range fixture.frames {
+		binary.LittleEndian.PutUint32(animation[8 + frameN * 4:], uint32(len(frameData)))
+		frameData = append(frameData, frame.bytes()...)
+	}
+	animation = append(animation, frameData...)
+	for len(animation) % 4 != 0 {
+		animation = append(animation, 0) // Flipnote Studio pads the frame data to 4 bytes
+	}
+	binary.LittleEndian.PutUint32(ppmBytes[0x4:], uint32(len(animation)))
+	ppmBytes = append(ppmBytes, animation...)
+
+	soundFlags := make([]byte, frameCount)
+	copy(soundFlags, fixture.soundFlags)
+	ppmBytes = append(ppmBytes, soundFlags...)
+	for len(ppmBytes) % 4 != 0 {
+		ppmBytes = append(ppmBytes, 0)
+	}
+
+	frameSpeed := fixture.frameSpeed
+	if frameSpeed == 0 {
+		frameSpeed = 6
+	}
+	bgmSpeed := fixture.bgmSpeed
+	if bgmSpeed == 0 {
+		bgmSpeed = frameSpeed
+	}
+	soundHeader := make([]byte, 32)
+	audioSize := 0
+	for track, trackData := range fixture.tracks {
+		binary.LittleEndian.PutUint32(soundHeader[track * 4:], uint32(len(trackData)))
+		audioSize += len(trackData)
+	}
+	soundHeader[16] = byte(8 - frameSpeed)
+	soundHeader[17] = byte(8 - bgmSpeed)
+	binary.LittleEndian.PutUint32(ppmBytes[0x8:], uint32(audioSize))
+	ppmBytes = append(ppmBytes, soundHeader...)
+	for _, trackData := range fixture.tracks {
+		ppmBytes = append(ppmBytes, trackData...)
+	}
+	return append(ppmBytes, make([]byte, 0x90)...)
+}
+
+// layersImage stores both layers of a frame in one grey image, layer 1's pen in the low two bits
+// and layer 2's in the next two, scaled up so the golden files can be looked at
+func layersImage(frameLayers *FrameLayers) *image.Gray {
+	layersImage := image.NewGray(image.Rect(0, 0, 256, 192))
+	for line := 0; line < 192; line++ {
+		for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
+			value := frameLayers.Layers[0][line][pixelPosition] | frameLayers.Layers[1][line][pixelPosition] << 2
+			layersImage.SetGray(pixelPosition, line, color.Gray{value * 16})
+		}
+	}
+	return layersImage
+}
@@ -0,0 +1,178 @@
+package ppm
+
+import (
+	"image"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// FrameCacheSize is the number of decoded frames each PPM keeps around for Frame
+var FrameCacheSize = 16
+
+type frameCache struct {
+	sync.Mutex
+	frames map[int]*FrameLayers
+	order []int // Least recently used first
+}
+
+func (cache *frameCache) get(frameN int) *FrameLayers {
+	frame, ok := cache.frames[frameN]
+	if !ok {
+		return nil
+	}
+	for i, cachedN := range cache.order {
+		if cachedN == frameN {
+			cache.order = append(append(cache.order[:i:i], cache.order[i+1:]...), frameN)
+			break
+		}
+	}
+	return frame
+}
+
+func (cache *frameCache) put(frameN int, frame *FrameLayers) {
+	if cache.frames == nil {
+		cache.frames = make(map[int]*FrameLayers)
+	}
+	if _, ok := cache.frames[frameN]; ok {
+		cache.get(frameN)
+		cache.frames[frameN] = frame
+		return
+	}
+	cache.frames[frameN] = frame
+	cache.order = append(cache.order, frameN)
+	for len(cache.order) > FrameCacheSize && len(cache.order) > 1 {
+		delete(cache.frames, cache.order[0])
+		cache.order = cache.order[1:]
+	}
+}
+
+// buildKeyframeIndex reads the header byte of every frame and records which ones are keyframes
+func buildKeyframeIndex(ppmReader *io.SectionReader, frameOffsets []uint32) ([]int, error) {
+	keyframes := []int{0} // The first frame has nothing to be a diff against
+	for frameN := 1; frameN < len(frameOffsets); frameN++ {
+		frameHeaderBytes, err := readAt(ppmReader, "FrameHeader", int64(frameOffsets[frameN]), 1)
+		if err != nil {
+			return nil, err
+		}
+		if (frameHeaderBytes[0] >> 7) & 0x1 > 0 {
+			keyframes = append(keyframes, frameN)
+		}
+	}
+	return keyframes, nil
+}
+
+// FrameLayers decodes frame n on demand without rendering it. The returned layers are the
+// composited frame, with any diff against previous frames already applied.
+func (ppmData *PPM) FrameLayers(n int) (*FrameLayers, error) {
+	decodedFrame, err := ppmData.unpackFrame(n)
+	if err != nil {
+		return nil, err
+	}
+	frameLayers := *decodedFrame // Copy so callers can't modify the cached frame
+	return &frameLayers, nil
+}
+
+// Frame decodes and renders frame n on demand, leaving out layers the animation flags hide, replaying diffs from the nearest keyframe or cached frame
+func (ppmData *PPM) Frame(n int) (*Frame, error) {
+	decodedFrame, err := ppmData.unpackFrame(n)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{FrameImage: getFrameImage(decodedFrame, ppmData.FrameData.layerHidden(), 1)}, nil
+}
+
+func (ppmData *PPM) unpackFrame(n int) (*FrameLayers, error) {
+	if n >= 0 && n < len(ppmData.FrameData.Frames) && ppmData.FrameData.Frames[n].Layers != nil {
+		return ppmData.FrameData.Frames[n].Layers, nil
+	}
+	if ppmData.reader == nil || ppmData.FrameData.FrameOffsets == nil {
+		return nil, ErrNoFrameData
+	}
+	if n < 0 || n >= len(ppmData.FrameData.FrameOffsets) {
+		return nil, ErrFrameIndex
+	}
+
+	ppmData.frameCache.Lock()
+	defer ppmData.frameCache.Unlock()
+
+	if cachedFrame := ppmData.frameCache.get(n); cachedFrame != nil {
+		return cachedFrame, nil
+	}
+
+	// Start from the nearest keyframe at or before n, or from a later cached frame if there is one
+	keyframes := ppmData.keyframes
+	keyframeN := keyframes[sort.SearchInts(keyframes, n + 1) - 1]
+	startN := keyframeN
+	prevFrame := &FrameLayers{}
+	for cachedN := n - 1; cachedN >= keyframeN; cachedN-- {
+		if cachedFrame := ppmData.frameCache.frames[cachedN]; cachedFrame != nil {
+			startN = cachedN + 1
+			prevFrame = cachedFrame
+			break
+		}
+	}
+
+	for frameN := startN; frameN <= n; frameN++ {
+		debugLog("> Decoding frame " + strconv.Itoa(frameN) + " towards frame " + strconv.Itoa(n) + "...")
+		currentFrame, err := decodeFrame(ppmData.reader, ppmData, frameN)
+		if err != nil {
+			return nil, err
+		}
+		if !currentFrame.IsNewFrame {
+			applyFrameDiff(currentFrame, prevFrame)
+		}
+		ppmData.frameCache.put(frameN, currentFrame)
+		prevFrame = currentFrame
+	}
+	return prevFrame, nil
+}
+
+// applyFrameDiff XORs the layers of a diff frame with the previous frame. A translated frame
+// is XORed with the previous frame moved by its translate offsets, clipping whatever is moved
+// off the edges. The result takes the current frame's pen colours.
+func applyFrameDiff(currentFrame *FrameLayers, prevFrame *FrameLayers) {
+	translateX := 0
+	translateY := 0
+	if currentFrame.IsTranslated {
+		translateX = currentFrame.TranslateX
+		translateY = currentFrame.TranslateY
+	}
+	for layer := 0; layer < 2; layer++ {
+		penColor := byte(currentFrame.PenColor[layer])
+		for line := 0; line < 192; line++ {
+			prevLine := line - translateY
+			for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
+				prevPixelPosition := pixelPosition - translateX
+				prevInk := false
+				if prevLine >= 0 && prevLine < 192 && prevPixelPosition >= 0 && prevPixelPosition < 256 {
+					prevInk = prevFrame.Layers[layer][prevLine][prevPixelPosition] != 0
+				}
+				currentInk := currentFrame.Layers[layer][line][pixelPosition] != 0
+				if currentInk != prevInk {
+					currentFrame.Layers[layer][line][pixelPosition] = penColor
+				} else {
+					currentFrame.Layers[layer][line][pixelPosition] = 0
+				}
+			}
+		}
+	}
+}
+
+// Image renders both layers onto the paper colour
+func (frameLayers *FrameLayers) Image() image.Image {
+	return getFrameImage(frameLayers, [2]bool{}, 1)
+}
+
+func (frameData *FrameData) layerHidden() [2]bool {
+	return [2]bool{frameData.Layer1Hidden, frameData.Layer2Hidden}
+}
+
+// frameCount returns how many frames can be decoded through FrameLayers
+func (ppmData *PPM) frameCount() int {
+	if ppmData.FrameData.FrameOffsets == nil {
+		return len(ppmData.FrameData.Frames) // Frames built in memory rather than decoded
+	}
+	return len(ppmData.FrameData.FrameOffsets)
+}
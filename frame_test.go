@@ -0,0 +1,39 @@
+package ppm
+
+import (
+	"bytes"
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// Frames decoded on demand, in any order and with barely any cache, match the frames decoded in order up front
+func TestFrameRandomOrder(t *testing.T) {
+	defer func(cacheSize int) { FrameCacheSize = cacheSize }(FrameCacheSize)
+	FrameCacheSize = 2
+
+	ppmBytes := randomFixture(5).build()
+	sequential, err := DecodeBytes(ppmBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lazy, err := Decode(bytes.NewReader(ppmBytes), int64(len(ppmBytes)), &OpenConfig{SkipFrames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	random := rand.New(rand.NewSource(3))
+	frameCount := len(sequential.FrameData.Frames)
+	for i := 0; i < frameCount * 4; i++ {
+		frameN := random.Intn(frameCount)
+		frame, err := lazy.Frame(frameN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := frame.FrameImage.(*image.Paletted)
+		want := sequential.FrameData.Frames[frameN].FrameImage.(*image.Paletted)
+		if !bytes.Equal(got.Pix, want.Pix) {
+			t.Fatalf("frame %d differs when decoded after %d other frames", frameN, i)
+		}
+	}
+}
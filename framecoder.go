@@ -0,0 +1,247 @@
+package ppm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+)
+
+// TranslateSearchRange is how far, in pixels along each axis, the encoder looks for the previous frame
+// having moved; 0 turns the search off
+var TranslateSearchRange = 8
+
+// Line encodings, as stored 2 bits per line in front of each layer's line data
+const (
+	lineEmpty = 0x0 // No ink
+	lineChunked = 0x1 // A chunk mask followed by the chunks that have ink
+	lineInverted = 0x2 // Ink everywhere, then a chunk mask followed by the chunks that have gaps
+	lineRaw = 0x3 // Every chunk, no mask
+)
+
+// encodeFrame writes a composited frame as a keyframe, a diff against the previous frame's ink, or a diff
+// against the previous frame's ink moved by a few pixels or by the frame's own translation, whichever is smallest. prevInk is nil for the
+// first frame, which is always a keyframe.
+func encodeFrame(frameLayers *FrameLayers, ink *[2][192][32]byte, prevInk *[2][192][32]byte) []byte {
+	frameHeader := byte(frameLayers.PaperColor & 0x1)
+	frameHeader |= byte(frameLayers.PenColor[0] & 0x3) << 1
+	frameHeader |= byte(frameLayers.PenColor[1] & 0x3) << 3
+
+	smallestFrame := append([]byte{frameHeader | 0x80}, encodeLayers(ink)...) // Keyframes win ties, since they let Frame start decoding there
+	if prevInk == nil {
+		return smallestFrame
+	}
+
+	diffFrame := append([]byte{frameHeader}, encodeLayers(diffLayers(ink, prevInk, 0, 0))...)
+	if len(diffFrame) < len(smallestFrame) {
+		smallestFrame = diffFrame
+	}
+
+	// A decoded frame's own move is tried as well, since it may lie outside TranslateSearchRange
+	translations := [][2]int{}
+	if translateX, translateY := findTranslation(ink, prevInk); translateX != 0 || translateY != 0 {
+		translations = append(translations, [2]int{translateX, translateY})
+	}
+	if frameLayers.IsTranslated && (frameLayers.TranslateX != 0 || frameLayers.TranslateY != 0) {
+		translations = append(translations, [2]int{frameLayers.TranslateX, frameLayers.TranslateY})
+	}
+	for _, translation := range translations {
+		translateX, translateY := translation[0], translation[1]
+		translatedFrame := append([]byte{frameHeader | 0x20, byte(int8(translateX)), byte(int8(translateY))}, encodeLayers(diffLayers(ink, prevInk, translateX, translateY))...)
+		if len(translatedFrame) < len(smallestFrame) {
+			smallestFrame = translatedFrame
+		}
+	}
+	return smallestFrame
+}
+
+// diffLayers XORs ink with prevInk moved by translateX and translateY, the way applyFrameDiff undoes it
+func diffLayers(ink *[2][192][32]byte, prevInk *[2][192][32]byte, translateX int, translateY int) *[2][192][32]byte {
+	diffInk := &[2][192][32]byte{}
+	for layer := 0; layer < 2; layer++ {
+		for line := 0; line < 192; line++ {
+			prevLine := [4]uint64{}
+			if line - translateY >= 0 && line - translateY < 192 {
+				prevLine = shiftLine(lineWords(&prevInk[layer][line - translateY]), translateX)
+			}
+			currentLine := lineWords(&ink[layer][line])
+			for word := 0; word < 4; word++ {
+				binary.LittleEndian.PutUint64(diffInk[layer][line][word * 8:], currentLine[word] ^ prevLine[word])
+			}
+		}
+	}
+	return diffInk
+}
+
+// findTranslation looks for the move of the previous frame, within TranslateSearchRange, that leaves the
+// fewest pixels in the diff. It returns 0, 0 when no move beats leaving the previous frame where it is.
+func findTranslation(ink *[2][192][32]byte, prevInk *[2][192][32]byte) (int, int) {
+	searchRange := TranslateSearchRange
+	if searchRange > 127 {
+		searchRange = 127 // Translate offsets are stored as signed bytes
+	}
+	if searchRange <= 0 {
+		return 0, 0
+	}
+
+	words := [2][192][4]uint64{}
+	prevWords := [2][192][4]uint64{}
+	prevEmpty := true
+	for layer := 0; layer < 2; layer++ {
+		for line := 0; line < 192; line++ {
+			words[layer][line] = lineWords(&ink[layer][line])
+			prevWords[layer][line] = lineWords(&prevInk[layer][line])
+			if prevWords[layer][line] != ([4]uint64{}) {
+				prevEmpty = false
+			}
+		}
+	}
+	if prevEmpty {
+		return 0, 0 // Moving nothing changes nothing
+	}
+
+	diffPixels := func(translateX int, translateY int, limit int) int {
+		pixels := 0
+		for layer := 0; layer < 2; layer++ {
+			for line := 0; line < 192; line++ {
+				prevLine := [4]uint64{}
+				if line - translateY >= 0 && line - translateY < 192 {
+					prevLine = shiftLine(prevWords[layer][line - translateY], translateX)
+				}
+				for word := 0; word < 4; word++ {
+					pixels += bits.OnesCount64(words[layer][line][word] ^ prevLine[word])
+				}
+			}
+			if pixels >= limit {
+				return pixels // Already no better than the best move so far
+			}
+		}
+		return pixels
+	}
+
+	bestX, bestY := 0, 0
+	bestPixels := diffPixels(0, 0, 2 * 192 * 256 + 1)
+	for translateY := -searchRange; translateY <= searchRange && bestPixels > 0; translateY++ {
+		for translateX := -searchRange; translateX <= searchRange; translateX++ {
+			if translateX == 0 && translateY == 0 {
+				continue
+			}
+			if pixels := diffPixels(translateX, translateY, bestPixels); pixels < bestPixels {
+				bestX, bestY, bestPixels = translateX, translateY, pixels
+			}
+		}
+	}
+	return bestX, bestY
+}
+
+// lineWords reads a packed line as four 64-bit words, pixel n being bit n % 64 of word n / 64
+func lineWords(line *[32]byte) [4]uint64 {
+	return [4]uint64{binary.LittleEndian.Uint64(line[0:]),
+		binary.LittleEndian.Uint64(line[8:]),
+		binary.LittleEndian.Uint64(line[16:]),
+		binary.LittleEndian.Uint64(line[24:])}
+}
+
+// shiftLine moves the pixels of a line right by shift pixels, or left when shift is negative, dropping whatever moves off the ends
+func shiftLine(words [4]uint64, shift int) [4]uint64 {
+	if shift >= 256 || shift <= -256 {
+		return [4]uint64{}
+	}
+	shifted := [4]uint64{}
+	if shift >= 0 {
+		wordShift := shift / 64
+		bitShift := uint(shift % 64)
+		for word := 3; word >= wordShift; word-- {
+			shifted[word] = words[word - wordShift] << bitShift
+			if bitShift > 0 && word - wordShift - 1 >= 0 {
+				shifted[word] |= words[word - wordShift - 1] >> (64 - bitShift)
+			}
+		}
+	} else {
+		wordShift := -shift / 64
+		bitShift := uint(-shift % 64)
+		for word := 0; word + wordShift < 4; word++ {
+			shifted[word] = words[word + wordShift] >> bitShift
+			if bitShift > 0 && word + wordShift + 1 < 4 {
+				shifted[word] |= words[word + wordShift + 1] << (64 - bitShift)
+			}
+		}
+	}
+	return shifted
+}
+
+// encodeLayers writes the line encodings of both layers followed by their line data
+func encodeLayers(ink *[2][192][32]byte) []byte {
+	lineEncodings := make([]byte, 96)
+	lineData := &bytes.Buffer{}
+	for layer := 0; layer < 2; layer++ {
+		for line := 0; line < 192; line++ {
+			lineType := encodeLine(lineData, &ink[layer][line])
+			lineEncodings[layer * 48 + line / 4] |= lineType << uint((line % 4) * 2)
+		}
+	}
+	return append(lineEncodings, lineData.Bytes()...)
+}
+
+// encodeLine writes a line using whichever of the four line encodings is smallest, and returns the one it used
+func encodeLine(lineData *bytes.Buffer, line *[32]byte) byte {
+	inkChunks := 0
+	gapChunks := 0
+	for _, chunkByte := range line {
+		if chunkByte != 0x00 {
+			inkChunks++
+		}
+		if chunkByte != 0xFF {
+			gapChunks++
+		}
+	}
+	if inkChunks == 0 {
+		return lineEmpty
+	}
+
+	// A chunk mask costs 4 bytes on top of the chunks it lists
+	chunkedSize := 4 + inkChunks
+	invertedSize := 4 + gapChunks
+	if chunkedSize <= invertedSize && chunkedSize < 32 {
+		writeLineChunks(lineData, line, 0x00)
+		return lineChunked
+	}
+	if invertedSize < 32 {
+		writeLineChunks(lineData, line, 0xFF)
+		return lineInverted
+	}
+	lineData.Write(line[:])
+	return lineRaw
+}
+
+// writeLineChunks writes a chunk mask, first chunk in the most significant bit, then every chunk that isn't skipChunk
+func writeLineChunks(lineData *bytes.Buffer, line *[32]byte, skipChunk byte) {
+	chunkMask := uint32(0)
+	for chunk, chunkByte := range line {
+		if chunkByte != skipChunk {
+			chunkMask |= 0x80000000 >> uint(chunk)
+		}
+	}
+	lineHeader := make([]byte, 4)
+	binary.BigEndian.PutUint32(lineHeader, chunkMask)
+	lineData.Write(lineHeader)
+	for _, chunkByte := range line {
+		if chunkByte != skipChunk {
+			lineData.WriteByte(chunkByte)
+		}
+	}
+}
+
+// packLayers packs the ink of both layers into 32 chunks of 8 pixels per line, least significant bit first, as unpackLineChunk reads them
+func packLayers(frameLayers *FrameLayers) *[2][192][32]byte {
+	ink := &[2][192][32]byte{}
+	for layer := 0; layer < 2; layer++ {
+		for line := 0; line < 192; line++ {
+			for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
+				if frameLayers.Layers[layer][line][pixelPosition] != 0 {
+					ink[layer][line][pixelPosition / 8] |= 1 << uint(pixelPosition % 8)
+				}
+			}
+		}
+	}
+	return ink
+}
@@ -0,0 +1,127 @@
+package ppm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestEncodeLine(t *testing.T) {
+	chunked := [32]byte{}
+	chunked[0], chunked[9], chunked[31] = 0x01, 0xF0, 0x80
+	inverted := stripedChunks(0xFF)
+	inverted[4], inverted[5] = 0x00, 0x7E
+	raw := stripedChunks(0x55)
+	raw[0] = 0xFF
+	evenSplit := stripedChunks(0xFF)
+	for chunk := 0; chunk < 16; chunk++ {
+		evenSplit[chunk] = 0x00
+	}
+
+	lineCases := []struct {
+		name string
+		line [32]byte
+		wantType byte
+		wantSize int
+	}{
+		{"empty", [32]byte{}, lineEmpty, 0},
+		{"chunked", chunked, lineChunked, 4 + 3},
+		{"inverted", inverted, lineInverted, 4 + 2},
+		{"full", stripedChunks(0xFF), lineInverted, 4},
+		{"even-split", evenSplit, lineChunked, 4 + 16}, // Ties go to the chunked line
+		{"raw", raw, lineRaw, 32},
+	}
+
+	for _, lineCase := range lineCases {
+		t.Run(lineCase.name, func(t *testing.T) {
+			lineData := &bytes.Buffer{}
+			lineType := encodeLine(lineData, &lineCase.line)
+			if lineType != lineCase.wantType || lineData.Len() != lineCase.wantSize {
+				t.Fatalf("encoded as type %d in %d bytes, want type %d in %d bytes", lineType, lineData.Len(), lineCase.wantType, lineCase.wantSize)
+			}
+			ink := referenceLine(fixtureLine{lineType: int(lineType), data: lineData.Bytes()})
+			for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
+				if ink[pixelPosition] != (lineCase.line[pixelPosition / 8] & (1 << uint(pixelPosition % 8)) != 0) {
+					t.Fatalf("pixel %d differs after encoding", pixelPosition)
+				}
+			}
+		})
+	}
+}
+
+// The encoder picks the smallest encoding of every line and frame, so re-encoding never grows the frame data
+func TestEncodeNoLarger(t *testing.T) {
+	fixtures := map[string]fixturePPM{"random": randomFixture(11)}
+	for _, conformanceCase := range conformanceCases {
+		fixtures[conformanceCase.name] = conformanceCase.fixture
+	}
+
+	for name, fixture := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			ppmData, err := DecodeBytes(fixture.build())
+			if err != nil {
+				t.Fatal(err)
+			}
+			reencoded := roundTrip(t, ppmData)
+			if reencoded.AnimationSize > ppmData.AnimationSize {
+				t.Fatalf("frame data grew from %d to %d bytes", ppmData.AnimationSize, reencoded.AnimationSize)
+			}
+		})
+	}
+}
+
+// scrollingImages returns frames of a speckled pattern whose content moves moveX, moveY pixels further each frame
+func scrollingImages(frameCount int, moveX int, moveY int) []image.Image {
+	random := rand.New(rand.NewSource(4))
+	pattern := [512][512]bool{}
+	for y := range pattern {
+		for x := range pattern[y] {
+			pattern[y][x] = random.Intn(5) == 0
+		}
+	}
+	frames := make([]image.Image, frameCount)
+	for frameN := range frames {
+		frameImage := image.NewGray(image.Rect(0, 0, 256, 192))
+		for y := 0; y < 192; y++ {
+			for x := 0; x < 256; x++ {
+				frameImage.SetGray(x, y, color.Gray{255})
+				if pattern[y - frameN * moveY + 100][x - frameN * moveX + 100] {
+					frameImage.SetGray(x, y, color.Gray{0})
+				}
+			}
+		}
+		frames[frameN] = frameImage
+	}
+	return frames
+}
+
+// Moved frames are stored as moved diffs, which for a scroll is far smaller than anything else
+func TestEncodeScroll(t *testing.T) {
+	defer func(searchRange int) { TranslateSearchRange = searchRange }(TranslateSearchRange)
+
+	ppmData, err := FromImages(scrollingImages(8, 3, 2), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[int]int{}
+	for _, searchRange := range []int{0, 8} {
+		TranslateSearchRange = searchRange
+		decoded := roundTrip(t, ppmData)
+		sizes[searchRange] = decoded.AnimationSize
+		for frameN := 1; frameN < 8; frameN++ {
+			frameLayers, err := decoded.FrameLayers(frameN)
+			if err != nil {
+				t.Fatal(err)
+			}
+			translated := frameLayers.IsTranslated && frameLayers.TranslateX == 3 && frameLayers.TranslateY == 2
+			if translated != (searchRange > 0) {
+				t.Fatalf("TranslateSearchRange %d: frame %d moved by %d, %d (%v)", searchRange, frameN, frameLayers.TranslateX, frameLayers.TranslateY, frameLayers.IsTranslated)
+			}
+		}
+	}
+	if sizes[8] * 2 > sizes[0] {
+		t.Fatalf("frame data is %d bytes with moved frames, %d without", sizes[8], sizes[0])
+	}
+}
@@ -0,0 +1,101 @@
+package ppm
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"time"
+)
+
+type GIFOptions struct {
+	Scale int // Integer scale factor, 1 if unset
+	Optimize bool // Store only the rectangle of pixels that changed since the previous frame
+}
+
+// EncodeGIF writes every frame of p as an animated GIF. Frames are stored with Flipnote's own
+// palette, so nothing is re-quantised, and loop forever if the animation flags say so.
+func EncodeGIF(w io.Writer, p *PPM, opts GIFOptions) error {
+	timing := p.Timing()
+	if !timing.Valid() {
+		return ErrBadFrameSpeed
+	}
+	frameCount := p.frameCount()
+	if frameCount == 0 {
+		return ErrNoFrameData
+	}
+	scale := opts.Scale
+	if scale < 1 {
+		scale = 1
+	}
+
+	// The extra transparent entry lets optimised frames leave unchanged pixels alone
+	gifPalette := append(color.Palette{}, framePaletteColors...)
+	transparentIndex := uint8(len(gifPalette))
+	gifPalette = append(gifPalette, color.RGBA{})
+
+	animation := &gif.GIF{
+		Image: make([]*image.Paletted, 0, frameCount),
+		Delay: make([]int, 0, frameCount),
+		Disposal: make([]byte, 0, frameCount),
+		LoopCount: -1, // Play once
+		Config: image.Config{ColorModel: gifPalette, Width: 256 * scale, Height: 192 * scale},
+	}
+	if p.FrameData.Loop {
+		animation.LoopCount = 0 // Loop forever
+	}
+
+	var prevImage *image.Paletted
+	for frameN := 0; frameN < frameCount; frameN++ {
+		frameLayers, err := p.FrameLayers(frameN)
+		if err != nil {
+			return err
+		}
+		frameImage := getFrameImage(frameLayers, p.FrameData.layerHidden(), scale)
+		frameImage.Palette = gifPalette
+
+		gifImage := frameImage
+		if opts.Optimize && prevImage != nil {
+			gifImage = changedRect(prevImage, frameImage, transparentIndex)
+		}
+		prevImage = frameImage
+
+		// GIF delays are in hundredths of a second, so round each frame's start time rather
+		// than its duration to keep the total in step with the flipnote
+		delay := int((timing.FrameStart(frameN + 1) + 5 * time.Millisecond) / (10 * time.Millisecond)) - int((timing.FrameStart(frameN) + 5 * time.Millisecond) / (10 * time.Millisecond))
+		animation.Image = append(animation.Image, gifImage)
+		animation.Delay = append(animation.Delay, delay)
+		animation.Disposal = append(animation.Disposal, gif.DisposalNone)
+	}
+
+	return gif.EncodeAll(w, animation)
+}
+
+// changedRect returns the smallest part of currentImage that differs from prevImage, with
+// pixels that did not change set to transparentIndex
+func changedRect(prevImage *image.Paletted, currentImage *image.Paletted, transparentIndex uint8) *image.Paletted {
+	bounds := currentImage.Bounds()
+	changed := image.Rectangle{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if prevImage.ColorIndexAt(x, y) != currentImage.ColorIndexAt(x, y) {
+				changed = changed.Union(image.Rect(x, y, x + 1, y + 1))
+			}
+		}
+	}
+	if changed.Empty() {
+		changed = image.Rect(0, 0, 1, 1) // GIF frames can't be empty
+	}
+
+	changedImage := image.NewPaletted(changed, currentImage.Palette)
+	for y := changed.Min.Y; y < changed.Max.Y; y++ {
+		for x := changed.Min.X; x < changed.Max.X; x++ {
+			if prevImage.ColorIndexAt(x, y) == currentImage.ColorIndexAt(x, y) {
+				changedImage.SetColorIndex(x, y, transparentIndex)
+			} else {
+				changedImage.SetColorIndex(x, y, currentImage.ColorIndexAt(x, y))
+			}
+		}
+	}
+	return changedImage
+}
@@ -0,0 +1,192 @@
+package ppm
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"math"
+	"time"
+)
+
+type ImportOptions struct {
+	FrameSpeed int // 1 to 8; if unset it is picked from Delays, or 6 (12 fps) without them
+	Delays []int // Per frame delays in hundredths of a second, as in gif.GIF
+	Loop bool
+	PreviewFrame int // The frame the thumbnail is made from
+	AuthorName string
+	AuthorID string // 16 hex digits, "0000000000000000" if unset
+	FileName string // Made up from Date if unset
+	Date time.Time // time.Now() if unset
+	Quantize QuantizeOptions // How each image is reduced to Flipnote's colours
+}
+
+// FromImages builds a flipnote out of a sequence of images, ready for Encode. Each image is scaled
+// to 256x192 and reduced to a paper colour and at most two pen colours by Quantize.
+func FromImages(frames []image.Image, opts ImportOptions) (*PPM, error) {
+	if len(frames) == 0 {
+		return nil, ErrNoFrameData
+	}
+	if len(frames) > 999 {
+		return nil, ErrFrameCount
+	}
+	frameSpeed := opts.FrameSpeed
+	if frameSpeed == 0 {
+		frameSpeed = frameSpeedFromDelays(opts.Delays)
+	}
+	if !(Timing{FrameSpeed: frameSpeed}).Valid() {
+		return nil, ErrBadFrameSpeed
+	}
+	if opts.PreviewFrame < 0 || opts.PreviewFrame >= len(frames) {
+		return nil, ErrFrameIndex
+	}
+
+	authorID := opts.AuthorID
+	if authorID == "" {
+		authorID = "0000000000000000"
+	}
+	date := opts.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	fileName := opts.FileName
+	if fileName == "" {
+		fileName = fmt.Sprintf("000000_%013X_000", uint64(date.UnixNano()) & 0xFFFFFFFFFFFFF)
+	}
+
+	ppmData := &PPM{}
+	ppmData.Meta = Meta{AuthorName: opts.AuthorName,
+		Date: date.Unix(),
+		FileName: fileName,
+		FrameCount: len(frames),
+		LastEditedAuthorID: authorID,
+		LastEditedAuthorName: opts.AuthorName,
+		OriginalAuthorID: authorID,
+		OriginalAuthorName: opts.AuthorName,
+		OriginalFileName: fileName,
+		PreviewFrame: opts.PreviewFrame,
+		PreviousEditingAuthorID: authorID}
+
+	ppmData.FrameData.Frames = make([]Frame, len(frames))
+	for frameN, frameImage := range frames {
+		if frameImage == nil {
+			return nil, fmt.Errorf("ppm: frame %d has no image", frameN)
+		}
+		frameLayers := Quantize(frameImage, opts.Quantize)
+		ppmData.FrameData.Frames[frameN] = Frame{FrameImage: getFrameImage(frameLayers, [2]bool{}, 1), Layers: frameLayers}
+	}
+	ppmData.FrameData.Loop = opts.Loop
+
+	ppmData.PreviewFrameBitmap, ppmData.PreviewFrameImage = makeThumbnail(ppmData.FrameData.Frames[opts.PreviewFrame].Layers)
+
+	ppmData.SoundData.SoundMeta.FrameSpeed = frameSpeed
+	ppmData.SoundData.SoundMeta.BGMSpeed = frameSpeed
+	ppmData.Success = true
+	return ppmData, nil
+}
+
+// FromGIF builds a flipnote out of every frame of an animated GIF, taking the frame speed from its delays
+// unless opts sets one, and looping if the GIF loops.
+func FromGIF(animation *gif.GIF, opts ImportOptions) (*PPM, error) {
+	if len(animation.Image) == 0 {
+		return nil, ErrNoFrameData
+	}
+	bounds := image.Rect(0, 0, animation.Config.Width, animation.Config.Height)
+	if bounds.Empty() {
+		bounds = animation.Image[0].Bounds()
+	}
+
+	// GIF frames may only cover what changed, so draw each one over what came before
+	canvas := image.NewRGBA(bounds)
+	frames := make([]image.Image, len(animation.Image))
+	for frameN, gifImage := range animation.Image {
+		disposal := byte(0)
+		if frameN < len(animation.Disposal) {
+			disposal = animation.Disposal[frameN]
+		}
+		var prevCanvas *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			prevCanvas = image.NewRGBA(bounds)
+			copy(prevCanvas.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, gifImage.Bounds(), gifImage, gifImage.Bounds().Min, draw.Over)
+		frameImage := image.NewRGBA(bounds)
+		copy(frameImage.Pix, canvas.Pix)
+		frames[frameN] = frameImage
+
+		switch disposal {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, gifImage.Bounds(), image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = prevCanvas
+		}
+	}
+
+	if opts.Delays == nil {
+		opts.Delays = animation.Delay
+	}
+	if animation.LoopCount == 0 {
+		opts.Loop = true
+	}
+	return FromImages(frames, opts)
+}
+
+// frameSpeedFromDelays picks the frame speed whose frame duration is closest to the average delay
+func frameSpeedFromDelays(delays []int) int {
+	totalDelay := 0
+	for _, delay := range delays {
+		totalDelay += delay
+	}
+	if totalDelay <= 0 {
+		return 6
+	}
+	averageDelay := float64(totalDelay) / float64(len(delays)) / 100
+
+	bestSpeed := 1
+	bestDistance := math.Inf(1)
+	for frameSpeed := 1; frameSpeed <= 8; frameSpeed++ {
+		distance := math.Abs(math.Log(averageDelay * Timing{FrameSpeed: frameSpeed}.FPS())) // Compare ratios, since the speeds are spread out geometrically
+		if distance < bestDistance {
+			bestSpeed, bestDistance = frameSpeed, distance
+		}
+	}
+	return bestSpeed
+}
+
+// Thumbnail colours standing in for each frame colour
+var thumbnailFrameColors = [4]byte{framePaletteBlack: 0x1, framePaletteWhite: 0x2, framePaletteRed: 0x4, framePaletteBlue: 0x8}
+
+// makeThumbnail shrinks a frame to the 64x48 thumbnail, using the most common colour of each 4x4 block
+// of pixels. It returns the thumbnail as stored in the header, in 8x8 tiles of 4 bits per pixel, and as an image.
+func makeThumbnail(frameLayers *FrameLayers) ([]byte, image.Image) {
+	frameImage := getFrameImage(frameLayers, [2]bool{}, 1)
+	previewBitmap := make([]byte, 1536)
+	previewImage := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for imageY := 0; imageY < 48; imageY++ {
+		for imageX := 0; imageX < 64; imageX++ {
+			colorCounts := [4]int{}
+			for blockY := 0; blockY < 4; blockY++ {
+				for blockX := 0; blockX < 4; blockX++ {
+					colorCounts[frameImage.ColorIndexAt(imageX * 4 + blockX, imageY * 4 + blockY)]++
+				}
+			}
+			blockColor := 0
+			for colorIndex := range colorCounts {
+				if colorCounts[colorIndex] > colorCounts[blockColor] {
+					blockColor = colorIndex
+				}
+			}
+			colorIndex := thumbnailFrameColors[blockColor]
+			previewImage.Set(imageX, imageY, thumbnailPalette[colorIndex])
+
+			colorLoc := ((imageY / 8) * 512 + (imageX / 8) * 64 + (imageY % 8) * 8 + imageX % 8) / 2
+			if imageX % 2 == 0 {
+				previewBitmap[colorLoc] |= colorIndex
+			} else {
+				previewBitmap[colorLoc] |= colorIndex << 4
+			}
+		}
+	}
+	return previewBitmap, previewImage
+}
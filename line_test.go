@@ -0,0 +1,106 @@
+package ppm
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// referenceLine unpacks a fixture line straight from the format description, as a check on unpackLineChunk:
+// chunk n covers pixels n * 8 to n * 8 + 7, least significant bit first, and type 1 and 2 masks
+// flag chunk 0 in their most significant bit
+func referenceLine(fixture fixtureLine) [256]bool {
+	ink := [256]bool{}
+	switch fixture.lineType {
+		case 1, 2:
+			chunkMask := binary.BigEndian.Uint32(fixture.data[:4])
+			chunkBytes := fixture.data[4:]
+			for chunk := 0; chunk < 32; chunk++ {
+				flagged := chunkMask & (1 << uint(31 - chunk)) != 0
+				for bit := 0; bit < 8; bit++ {
+					if flagged {
+						ink[chunk * 8 + bit] = chunkBytes[0] & (1 << uint(bit)) != 0
+					} else {
+						ink[chunk * 8 + bit] = fixture.lineType == 2
+					}
+				}
+				if flagged {
+					chunkBytes = chunkBytes[1:]
+				}
+			}
+		case 3:
+			for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
+				ink[pixelPosition] = fixture.data[pixelPosition / 8] & (1 << uint(pixelPosition % 8)) != 0
+			}
+	}
+	return ink
+}
+
+// checkLines decodes one keyframe holding lines and compares every pixel of both layers with referenceLine
+func checkLines(t *testing.T, pens [2]byte, lines [2]map[int]fixtureLine) {
+	t.Helper()
+	ppmData, err := DecodeBytes(fixturePPM{frames: []fixtureFrame{{keyframe: true, paper: PaperBlack, pens: pens, lines: lines}}}.build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	frameLayers, err := ppmData.FrameLayers(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for layer := 0; layer < 2; layer++ {
+		pen := pens[layer]
+		if pen == 0 {
+			pen = byte(PenInverse)
+		}
+		for line := 0; line < 192; line++ {
+			ink := referenceLine(lines[layer][line])
+			for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
+				want := byte(0)
+				if ink[pixelPosition] {
+					want = pen
+				}
+				if got := frameLayers.Layers[layer][line][pixelPosition]; got != want {
+					t.Fatalf("layer %d pixel (%d, %d) = %d, want %d", layer, pixelPosition, line, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRawLines(t *testing.T) {
+	random := rand.New(rand.NewSource(9))
+	lines := [2]map[int]fixtureLine{{}, {}}
+	for layer := 0; layer < 2; layer++ {
+		// Lines that show the bit order plainly, then random ones
+		for line, chunkByte := range []byte{0x00, 0xFF, 0x01, 0x80, 0x55, 0xAA, 0x0F, 0xF0} {
+			lines[layer][line] = rawLine(stripedChunks(chunkByte))
+		}
+		onePixel := [32]byte{}
+		onePixel[31] = 0x80
+		lines[layer][8] = rawLine(onePixel)
+		for line := 9; line < 192; line += 1 + layer {
+			chunks := [32]byte{}
+			random.Read(chunks[:])
+			lines[layer][line] = rawLine(chunks)
+		}
+	}
+	for _, pens := range [][2]byte{{1, 2}, {3, 0}} {
+		checkLines(t, pens, lines)
+	}
+}
+
+func TestInvertedLines(t *testing.T) {
+	allCleared := map[int]byte{}
+	for chunk := 0; chunk < 32; chunk++ {
+		allCleared[chunk] = 0x00
+	}
+	lines := [2]map[int]fixtureLine{
+		{0: invertedLine(map[int]byte{}), // No chunks, so every pixel has ink
+			1: invertedLine(map[int]byte{0: 0x00}), // Unset bits clear to no ink
+			2: invertedLine(map[int]byte{31: 0x0F, 7: 0xA5}),
+			3: invertedLine(allCleared)},
+		{10: invertedLine(map[int]byte{15: 0x00, 16: 0xFF}),
+			191: invertedLine(map[int]byte{0: 0x01, 1: 0x80, 30: 0x7E})},
+	}
+	checkLines(t, [2]byte{2, 3}, lines)
+}
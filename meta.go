@@ -0,0 +1,283 @@
+package ppm
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Meta holds everything stored in the first 0x6A0 bytes of a PPM: the lock
+// flag, authors, file names, date, section sizes, frame count and thumbnail.
+type Meta struct {
+	AnimationSize int
+	AudioSize int
+	AuthorName string
+	Date int64
+	FileName string
+	FrameCount int
+	LastEditedAuthorID string
+	LastEditedAuthorName string
+	Locked bool
+	OriginalAuthorID string
+	OriginalAuthorName string
+	OriginalFileName string
+	PartialFileName string
+	PreviewFrame int
+	PreviewFrameBitmap []byte
+	PreviewFrameImage image.Image
+	PreviousEditingAuthorID string
+}
+
+const metaSize = 0x06A0
+
+// ReadMeta parses only the PPM header, without touching the animation or sound sections.
+func ReadMeta(r io.ReaderAt) (*Meta, error) {
+	meta := &Meta{}
+	if err := meta.decode(io.NewSectionReader(r, 0, metaSize), &OpenConfig{}); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (meta *Meta) decode(ppmReader *io.SectionReader, openConfig *OpenConfig) (error) {
+	if !openConfig.SkipMagicCheck {
+		magic, err := readAt(ppmReader, "Magic", 0x0, 4)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(ppmMagic, magic) {
+			return &FormatError{Field: "Magic", Offset: 0x0, Err: ErrBadMagic}
+		}
+	}
+
+	if !openConfig.SkipAudioSize {
+		audioSize, err := readAt(ppmReader, "AudioSize", 0x8, 4)
+		if err != nil {
+			return err
+		}
+		meta.AudioSize = hex2int(binaryReadLE(audioSize))
+	}
+
+	if !openConfig.SkipLockStatus {
+		lockStatus, err := readAt(ppmReader, "Locked", 0x10, 2)
+		if err != nil {
+			return err
+		}
+		if hex2int(lockStatus) > 0 {
+			meta.Locked = true
+		} else {
+			meta.Locked = false
+		}
+	}
+
+	if !openConfig.SkipOriginalAuthorName {
+		originalAuthorName, err := readAt(ppmReader, "OriginalAuthorName", 0x14, 22)
+		if err != nil {
+			return err
+		}
+		meta.OriginalAuthorName = decodeAuthorName(originalAuthorName)
+	}
+
+	if !openConfig.SkipLastEditedAuthorName {
+		lastEditedAuthorName, err := readAt(ppmReader, "LastEditedAuthorName", 0x2A, 22)
+		if err != nil {
+			return err
+		}
+		meta.LastEditedAuthorName = decodeAuthorName(lastEditedAuthorName)
+	}
+
+	if !openConfig.SkipAuthorName {
+		authorName, err := readAt(ppmReader, "AuthorName", 0x40, 22)
+		if err != nil {
+			return err
+		}
+		meta.AuthorName = decodeAuthorName(authorName)
+	}
+
+	if !openConfig.SkipOriginalAuthorID {
+		originalAuthorIDBytes, err := readAt(ppmReader, "OriginalAuthorID", 0x56, 8)
+		if err != nil {
+			return err
+		}
+		originalAuthorID := binaryReadLE(originalAuthorIDBytes)
+		meta.OriginalAuthorID = strings.ToUpper(hexAsString(originalAuthorID))
+		if !openConfig.SkipOriginalAuthorIDCheck {
+			regexpIDMatch, _ := regexp.MatchString(regexID, meta.OriginalAuthorID)
+			if !regexpIDMatch { return &FormatError{Field: "OriginalAuthorID", Offset: 0x56, Err: ErrInvalidAuthorID} }
+		}
+	}
+
+	if !openConfig.SkipLastEditedAuthorID {
+		lastEditedAuthorIDBytes, err := readAt(ppmReader, "LastEditedAuthorID", 0x5E, 8)
+		if err != nil {
+			return err
+		}
+		lastEditedAuthorID := binaryReadLE(lastEditedAuthorIDBytes)
+		meta.LastEditedAuthorID = strings.ToUpper(hexAsString(lastEditedAuthorID))
+		if !openConfig.SkipLastEditedAuthorIDCheck {
+			regexpIDMatch, _ := regexp.MatchString(regexID, meta.LastEditedAuthorID)
+			if !regexpIDMatch { return &FormatError{Field: "LastEditedAuthorID", Offset: 0x5E, Err: ErrInvalidAuthorID} }
+		}
+	}
+
+	if !openConfig.SkipPreviousEditingAuthorID {
+		previousEditingAuthorIDBytes, err := readAt(ppmReader, "PreviousEditingAuthorID", 0x8A, 8)
+		if err != nil {
+			return err
+		}
+		previousEditingAuthorID := binaryReadLE(previousEditingAuthorIDBytes)
+		meta.PreviousEditingAuthorID = strings.ToUpper(hexAsString(previousEditingAuthorID))
+		if !openConfig.SkipPreviousEditingAuthorIDCheck {
+			regexpIDMatch, _ := regexp.MatchString(regexID, meta.PreviousEditingAuthorID)
+			if !regexpIDMatch { return &FormatError{Field: "PreviousEditingAuthorID", Offset: 0x8A, Err: ErrInvalidAuthorID} }
+		}
+	}
+
+	if !openConfig.SkipOriginalFileName {
+		originalFileName1, err := readAt(ppmReader, "OriginalFileName", 0x66, 3)
+		if err != nil {
+			return err
+		}
+		originalFileName2, err := readAt(ppmReader, "OriginalFileName", 0x69, 13)
+		if err != nil {
+			return err
+		}
+		originalFileName3, err := readAt(ppmReader, "OriginalFileName", 0x76, 2)
+		if err != nil {
+			return err
+		}
+
+		meta.OriginalFileName = strings.ToUpper(hexAsString(originalFileName1) + "_" + hex2string(originalFileName2, false) + "_" + padLeft(strconv.Itoa(int(binaryReadLE_uint16(originalFileName3))), "0", 3))
+		if !openConfig.SkipOriginalFileNameCheck {
+			regexpFileNameMatch, _ := regexp.MatchString(regexFileName, meta.OriginalFileName)
+			if !regexpFileNameMatch { return &FormatError{Field: "OriginalFileName", Offset: 0x66, Err: ErrInvalidFileName} }
+		}
+	}
+
+	if !openConfig.SkipFileName {
+		fileName1, err := readAt(ppmReader, "FileName", 0x78, 3)
+		if err != nil {
+			return err
+		}
+		fileName2, err := readAt(ppmReader, "FileName", 0x7B, 13)
+		if err != nil {
+			return err
+		}
+		fileName3, err := readAt(ppmReader, "FileName", 0x88, 2)
+		if err != nil {
+			return err
+		}
+
+		meta.FileName = strings.ToUpper(hexAsString(fileName1) + "_" + hex2string(fileName2, false) + "_" + padLeft(strconv.Itoa(int(binaryReadLE_uint16(fileName3))), "0", 3))
+		if !openConfig.SkipFileNameCheck {
+			regexpFileNameMatch, _ := regexp.MatchString(regexFileName, meta.FileName)
+			if !regexpFileNameMatch { return &FormatError{Field: "FileName", Offset: 0x78, Err: ErrInvalidFileName} }
+		}
+	}
+
+	if !openConfig.SkipPartialFileName {
+		partialFileName, err := readAt(ppmReader, "PartialFileName", 0x92, 8)
+		if err != nil {
+			return err
+		}
+		meta.PartialFileName = hex2string(partialFileName, false)
+	}
+
+	if !openConfig.SkipDate {
+		date, err := readAt(ppmReader, "Date", 0x9A, 4)
+		if err != nil {
+			return err
+		}
+		meta.Date = (int64(binaryReadLE_uint32(date)) + 946684800) // Seconds since 2000-01-01
+	}
+
+	if !openConfig.SkipAnimationSize {
+		animationSize, err := decodeAnimationSize(ppmReader)
+		if err != nil {
+			return err
+		}
+		meta.AnimationSize = animationSize
+	}
+
+	if !openConfig.SkipFrameCount {
+		frameCount, err := decodeFrameCount(ppmReader)
+		if err != nil {
+			return err
+		}
+		meta.FrameCount = frameCount
+	}
+
+	if !openConfig.SkipPreviewFrameN {
+		previewFrameN, err := readAt(ppmReader, "PreviewFrame", 0x12, 2)
+		if err != nil {
+			return err
+		}
+		meta.PreviewFrame = int(binaryReadLE_uint16(previewFrameN))
+	}
+
+	if !openConfig.SkipThumbnail {
+		previewBitmap, err := readAt(ppmReader, "PreviewFrameBitmap", 0xA0, 1536)
+		if err != nil {
+			return err
+		}
+		meta.PreviewFrameBitmap = previewBitmap
+
+		previewImage := image.NewRGBA(image.Rect(0, 0, 64, 48))
+		for tileY := 0; tileY < 6; tileY++ {
+			for tileX := 0; tileX < 8; tileX++ {
+				for imageY := 0; imageY < 8; imageY++ {
+					for imageX := 0; imageX < 8; imageX += 2 {
+						colorLoc := (tileY * 512 + tileX * 64 + imageY * 8 + imageX) / 2
+						colorByte := previewBitmap[colorLoc]
+						color1 := singleHex2int(colorByte & 0xF)
+						color2 := singleHex2int(colorByte >> 4)
+						rgbaColor1 := thumbnailPalette[color1]
+						rgbaColor2 := thumbnailPalette[color2]
+						previewImage.Set(imageX + tileX * 8, imageY + tileY * 8, rgbaColor1)
+						previewImage.Set(imageX + tileX * 8 + 1, imageY + tileY * 8, rgbaColor2)
+					}
+				}
+			}
+		}
+		meta.PreviewFrameImage = previewImage
+	}
+
+	return nil
+}
+
+func decodeAnimationSize(ppmReader *io.SectionReader) (int, error) {
+	animationSizeBytes, err := readAt(ppmReader, "AnimationSize", 0x4, 4)
+	if err != nil {
+		return 0, err
+	}
+	return int(binaryReadLE_uint32(animationSizeBytes)), nil
+}
+
+func decodeFrameCount(ppmReader *io.SectionReader) (int, error) {
+	frameCountBytes, err := readAt(ppmReader, "FrameCount", 0xC, 2)
+	if err != nil {
+		return 0, err
+	}
+	frameCount := int(binaryReadLE_uint16(frameCountBytes)) + 1
+	if frameCount > 999 {
+		frameCount = 999
+	}
+	return frameCount, nil
+}
+
+// decodeAuthorName reads a name stored as up to 11 zero-padded UTF-16LE characters
+func decodeAuthorName(nameBytes []byte) string {
+	nameChars := make([]uint16, 0, len(nameBytes) / 2)
+	for i := 0; i + 1 < len(nameBytes); i += 2 {
+		nameChar := binaryReadLE_uint16(nameBytes[i:i + 2])
+		if nameChar == 0 {
+			break
+		}
+		nameChars = append(nameChars, nameChar)
+	}
+	return string(utf16.Decode(nameChars))
+}
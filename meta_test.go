@@ -0,0 +1,56 @@
+package ppm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// ReadMeta decodes every header field, and reads nothing past the header
+func TestReadMeta(t *testing.T) {
+	ppmBytes := optionsFixture()
+	copy(ppmBytes[0x40:0x56], []byte{'Z', 0, 'o', 0, 0xEB, 0, 0, 0}) // "Zoë", which needs UTF-16 rather than ASCII
+	binary.LittleEndian.PutUint16(ppmBytes[0x12:], 290) // Past 255, so the whole 16-bit field counts
+	binary.LittleEndian.PutUint16(ppmBytes[0x88:], 123)
+	for i := 0; i < 1536; i++ {
+		ppmBytes[0xA0 + i] = byte(i)
+	}
+
+	reader := &recordingReader{r: bytes.NewReader(ppmBytes)}
+	meta, err := ReadMeta(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Meta{AnimationSize: int(binary.LittleEndian.Uint32(ppmBytes[0x4:])),
+		AudioSize: 6,
+		AuthorName: "Zoë",
+		Date: 0x12345678 + 946684800,
+		FileName: "F78DA8_14FBF5B16B2A0_123",
+		FrameCount: 2,
+		LastEditedAuthorID: fixtureAuthorID,
+		LastEditedAuthorName: "Fix",
+		Locked: true,
+		OriginalAuthorID: fixtureAuthorID,
+		OriginalAuthorName: "Fix",
+		OriginalFileName: fixtureFileName,
+		PartialFileName: "14FBF5B1",
+		PreviewFrame: 290,
+		PreviousEditingAuthorID: fixtureAuthorID}
+	got := *meta
+	if !bytes.Equal(got.PreviewFrameBitmap, ppmBytes[0xA0:0x6A0]) || got.PreviewFrameImage == nil {
+		t.Fatal("thumbnail was not decoded")
+	}
+	got.PreviewFrameBitmap, got.PreviewFrameImage = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReadMeta() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if len(reader.reads) == 0 {
+		t.Fatal("nothing was read")
+	}
+	if reader.touched(metaSize, int64(len(ppmBytes))) {
+		t.Fatalf("read past the header: %v", reader.reads)
+	}
+}
@@ -0,0 +1,226 @@
+package ppm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// recordingReader remembers every range read through it
+type recordingReader struct {
+	r io.ReaderAt
+	reads [][2]int64
+}
+
+func (reader *recordingReader) ReadAt(p []byte, off int64) (int, error) {
+	reader.reads = append(reader.reads, [2]int64{off, off + int64(len(p))})
+	return reader.r.ReadAt(p, off)
+}
+
+// touched reports whether any read overlapped the length bytes at offset
+func (reader *recordingReader) touched(offset int64, length int64) bool {
+	for _, read := range reader.reads {
+		if read[0] < offset + length && offset < read[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// optionsFixture has a value in every field an OpenConfig flag can skip
+func optionsFixture() []byte {
+	frame := fixtureFrame{keyframe: true, paper: PaperWhite, pens: [2]byte{1, 2}, lines: boxLines(10, 16)}
+	ppmBytes := fixturePPM{frames: []fixtureFrame{frame, frame},
+		soundFlags: []byte{0x1, 0x6},
+		tracks: [4][]byte{{0x12, 0x34, 0x56, 0x78}, {0x9A, 0xBC}},
+		frameSpeed: 5,
+		previewFrame: 1}.build()
+	ppmBytes[0x10] = 1 // Locked
+	copy(ppmBytes[0x92:], "14FBF5B1")
+	return ppmBytes
+}
+
+// Each Skip flag leaves its field zero-valued and never reads the bytes it is stored in
+func TestOpenConfigSkip(t *testing.T) {
+	skipCases := []struct {
+		name string
+		config OpenConfig
+		zero func(ppmData *PPM) bool
+		skipped func(ppmData *PPM) (int64, int64) // The bytes that must not be read, found from a full decode
+	}{
+		{"AnimationSize", OpenConfig{SkipAnimationSize: true, SkipFrameData: true, SkipAudioData: true},
+			func(ppmData *PPM) bool { return ppmData.AnimationSize == 0 },
+			func(*PPM) (int64, int64) { return 0x4, 4 }},
+		{"AudioSize", OpenConfig{SkipAudioSize: true},
+			func(ppmData *PPM) bool { return ppmData.AudioSize == 0 },
+			func(*PPM) (int64, int64) { return 0x8, 4 }},
+		{"FrameCount", OpenConfig{SkipFrameCount: true, SkipFrameData: true, SkipAudioData: true},
+			func(ppmData *PPM) bool { return ppmData.FrameCount == 0 },
+			func(*PPM) (int64, int64) { return 0xC, 2 }},
+		{"LockStatus", OpenConfig{SkipLockStatus: true},
+			func(ppmData *PPM) bool { return !ppmData.Locked },
+			func(*PPM) (int64, int64) { return 0x10, 2 }},
+		{"PreviewFrameN", OpenConfig{SkipPreviewFrameN: true},
+			func(ppmData *PPM) bool { return ppmData.PreviewFrame == 0 },
+			func(*PPM) (int64, int64) { return 0x12, 2 }},
+		{"OriginalAuthorName", OpenConfig{SkipOriginalAuthorName: true},
+			func(ppmData *PPM) bool { return ppmData.OriginalAuthorName == "" },
+			func(*PPM) (int64, int64) { return 0x14, 22 }},
+		{"LastEditedAuthorName", OpenConfig{SkipLastEditedAuthorName: true},
+			func(ppmData *PPM) bool { return ppmData.LastEditedAuthorName == "" },
+			func(*PPM) (int64, int64) { return 0x2A, 22 }},
+		{"AuthorName", OpenConfig{SkipAuthorName: true},
+			func(ppmData *PPM) bool { return ppmData.AuthorName == "" },
+			func(*PPM) (int64, int64) { return 0x40, 22 }},
+		{"OriginalAuthorID", OpenConfig{SkipOriginalAuthorID: true},
+			func(ppmData *PPM) bool { return ppmData.OriginalAuthorID == "" },
+			func(*PPM) (int64, int64) { return 0x56, 8 }},
+		{"LastEditedAuthorID", OpenConfig{SkipLastEditedAuthorID: true},
+			func(ppmData *PPM) bool { return ppmData.LastEditedAuthorID == "" },
+			func(*PPM) (int64, int64) { return 0x5E, 8 }},
+		{"OriginalFileName", OpenConfig{SkipOriginalFileName: true},
+			func(ppmData *PPM) bool { return ppmData.OriginalFileName == "" },
+			func(*PPM) (int64, int64) { return 0x66, 18 }},
+		{"FileName", OpenConfig{SkipFileName: true},
+			func(ppmData *PPM) bool { return ppmData.FileName == "" },
+			func(*PPM) (int64, int64) { return 0x78, 18 }},
+		{"PreviousEditingAuthorID", OpenConfig{SkipPreviousEditingAuthorID: true},
+			func(ppmData *PPM) bool { return ppmData.PreviousEditingAuthorID == "" },
+			func(*PPM) (int64, int64) { return 0x8A, 8 }},
+		{"PartialFileName", OpenConfig{SkipPartialFileName: true},
+			func(ppmData *PPM) bool { return ppmData.PartialFileName == "" },
+			func(*PPM) (int64, int64) { return 0x92, 8 }},
+		{"Date", OpenConfig{SkipDate: true},
+			func(ppmData *PPM) bool { return ppmData.Date == 0 },
+			func(*PPM) (int64, int64) { return 0x9A, 4 }},
+		{"Thumbnail", OpenConfig{SkipThumbnail: true},
+			func(ppmData *PPM) bool { return ppmData.PreviewFrameBitmap == nil && ppmData.PreviewFrameImage == nil },
+			func(*PPM) (int64, int64) { return 0xA0, 1536 }},
+		{"FrameData", OpenConfig{SkipFrameData: true},
+			func(ppmData *PPM) bool { return ppmData.FrameData.FrameOffsets == nil && ppmData.FrameData.Frames == nil },
+			func(ppmData *PPM) (int64, int64) { return 0x6A8, int64(ppmData.AnimationSize - 8) }},
+		{"Frames", OpenConfig{SkipFrames: true},
+			func(ppmData *PPM) bool { return ppmData.FrameData.Frames == nil },
+			func(ppmData *PPM) (int64, int64) { return int64(ppmData.FrameData.FrameOffsets[0] + 1), 96 }}, // Only the frame header byte is read, to index the keyframes
+		{"AudioData", OpenConfig{SkipAudioData: true},
+			func(ppmData *PPM) bool { return ppmData.SoundData.SoundEffectFlags == nil && ppmData.SoundData.BGM == nil && ppmData.SoundData.SoundEffect1 == nil },
+			func(ppmData *PPM) (int64, int64) { return int64(ppmData.SoundData.SoundMeta.BGM.Offset), int64(ppmData.AudioSize) }},
+	}
+
+	ppmBytes := optionsFixture()
+	full, err := DecodeBytes(ppmBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, skipCase := range skipCases {
+		t.Run(skipCase.name, func(t *testing.T) {
+			if skipCase.zero(full) {
+				t.Fatal("the fixture leaves this field zero-valued, so skipping it can't be seen")
+			}
+			reader := &recordingReader{r: bytes.NewReader(ppmBytes)}
+			config := skipCase.config
+			ppmData, err := Decode(reader, int64(len(ppmBytes)), &config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !skipCase.zero(ppmData) {
+				t.Error("field was decoded")
+			}
+			offset, length := skipCase.skipped(full)
+			if reader.touched(offset, length) {
+				t.Errorf("bytes 0x%X to 0x%X were read", offset, offset + length)
+			}
+		})
+	}
+}
+
+// Each Check flag lets through a value that would otherwise be rejected
+func TestOpenConfigSkipCheck(t *testing.T) {
+	checkCases := []struct {
+		name string
+		config OpenConfig
+		offset int
+		corrupt []byte
+	}{
+		{"Magic", OpenConfig{SkipMagicCheck: true}, 0x0, []byte("ARAP")},
+		{"OriginalAuthorID", OpenConfig{SkipOriginalAuthorIDCheck: true}, 0x5D, []byte{0xFF}},
+		{"LastEditedAuthorID", OpenConfig{SkipLastEditedAuthorIDCheck: true}, 0x65, []byte{0xFF}},
+		{"PreviousEditingAuthorID", OpenConfig{SkipPreviousEditingAuthorIDCheck: true}, 0x91, []byte{0xFF}},
+		{"OriginalFileName", OpenConfig{SkipOriginalFileNameCheck: true}, 0x69, []byte("$$")},
+		{"FileName", OpenConfig{SkipFileNameCheck: true}, 0x7B, []byte("$$")},
+	}
+
+	for _, checkCase := range checkCases {
+		t.Run(checkCase.name, func(t *testing.T) {
+			ppmBytes := optionsFixture()
+			copy(ppmBytes[checkCase.offset:], checkCase.corrupt)
+			if _, err := DecodeBytes(ppmBytes); err == nil {
+				t.Fatal("corrupt field was accepted without the flag")
+			}
+			config := checkCase.config
+			if _, err := Decode(bytes.NewReader(ppmBytes), int64(len(ppmBytes)), &config); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Skipping the audio still keeps the frame speed, so the flipnote can be played back
+func TestSkipAudioDataKeepsTiming(t *testing.T) {
+	ppmBytes := optionsFixture()
+	ppmData, err := Decode(bytes.NewReader(ppmBytes), int64(len(ppmBytes)), &OpenConfig{SkipAudioData: true, SkipFrames: true, SkipAnimationSize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timing := ppmData.Timing(); !timing.Valid() || timing.FrameSpeed != 5 {
+		t.Fatalf("Timing() = %+v", timing)
+	}
+	if err := EncodeGIF(io.Discard, ppmData, GIFOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Encode won't write out blanks for what was skipped when decoding, but can work out the sizes again
+func TestEncodeSkipped(t *testing.T) {
+	skippedCases := []struct {
+		name string
+		config OpenConfig
+		want error
+	}{
+		{"AudioData", OpenConfig{SkipAudioData: true}, ErrNoAudioData},
+		{"FrameData", OpenConfig{SkipFrameData: true}, ErrNoFrameData},
+		{"AuthorName", OpenConfig{SkipAuthorName: true}, ErrNoHeaderData},
+		{"Thumbnail", OpenConfig{SkipThumbnail: true}, ErrNoHeaderData},
+		{"PreviewFrameN", OpenConfig{SkipPreviewFrameN: true}, ErrNoHeaderData},
+		{"LockStatus", OpenConfig{SkipLockStatus: true}, ErrNoHeaderData},
+		{"Sizes", OpenConfig{SkipAnimationSize: true, SkipAudioSize: true, SkipFrameCount: true, SkipFrames: true}, nil},
+		{"Checks", OpenConfig{SkipMagicCheck: true, SkipFileNameCheck: true, SkipOriginalAuthorIDCheck: true}, nil},
+	}
+
+	ppmBytes := optionsFixture()
+	full, err := DecodeBytes(ppmBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullEncoded, err := full.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, skippedCase := range skippedCases {
+		t.Run(skippedCase.name, func(t *testing.T) {
+			config := skippedCase.config
+			ppmData, err := Decode(bytes.NewReader(ppmBytes), int64(len(ppmBytes)), &config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded := &bytes.Buffer{}
+			if err := ppmData.Encode(encoded); !errors.Is(err, skippedCase.want) {
+				t.Fatalf("Encode() = %v, want %v", err, skippedCase.want)
+			}
+			if skippedCase.want == nil && !bytes.Equal(encoded.Bytes(), fullEncoded) {
+				t.Fatal("encoded differently from a flipnote decoded in full")
+			}
+		})
+	}
+}
@@ -11,18 +11,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	//"io"
+	"io"
 	//"io/ioutil"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
-
-	"github.com/bovarysme/adpcm"
 )
 
 var (
@@ -52,19 +48,22 @@ var (
 		"red": color.RGBA{255, 42, 42, 255}} // Red
 )
 
+// Indexes into framePaletteColors, which every rendered frame uses as its palette
+const (
+	framePaletteBlack = iota
+	framePaletteWhite
+	framePaletteRed
+	framePaletteBlue
+)
+
+var framePaletteColors = color.Palette{framePaletteBlack: framePalette["black"],
+	framePaletteWhite: framePalette["white"],
+	framePaletteRed: framePalette["red"],
+	framePaletteBlue: framePalette["blue"]}
+
 type PPM struct {
-	AuthorName string
-	Date int64
-	FileName string
+	Meta
 	FrameData FrameData
-	LastEditedAuthorID string
-	LastEditedAuthorName string
-	Locked bool
-	OriginalAuthorID string
-	OriginalAuthorName string
-	OriginalFileName string
-	PartialFileName string
-	PreviousEditingAuthorID string
 	SoundData SoundData
 	
 	// Extra things
@@ -72,6 +71,10 @@ type PPM struct {
 	FileLocation string
 	OpenConfig *OpenConfig
 	ThumbnailPalette []color.RGBA
+
+	reader *io.SectionReader
+	keyframes []int
+	frameCache frameCache
 }
 type OpenConfig struct {
 	SkipAnimationSize bool
@@ -83,7 +86,7 @@ type OpenConfig struct {
 	SkipFileNameCheck bool
 	SkipFrameCount bool
 	SkipFrameData bool
-	SkipFrames bool
+	SkipFrames bool // Frames are not rendered up front, but can still be decoded with PPM.Frame
 	SkipLastEditedAuthorID bool
 	SkipLastEditedAuthorIDCheck bool
 	SkipLastEditedAuthorName bool
@@ -101,29 +104,61 @@ type OpenConfig struct {
 	SkipThumbnail bool
 }
 type FrameData struct {
+	// FrameCount is a copy of Meta.FrameCount, kept for older callers.
+	//
+	// Deprecated: Use Meta.FrameCount. Only filled in by Decode.
 	FrameCount int
 	FrameOffsets []uint32
 	Frames []Frame
+	// PreviewFrameBitmap is a copy of Meta.PreviewFrameBitmap, kept for older callers.
+	//
+	// Deprecated: Use Meta.PreviewFrameBitmap. Only filled in by Decode.
 	PreviewFrameBitmap []byte
+	// PreviewFrameImage is a copy of Meta.PreviewFrameImage, kept for older callers.
+	//
+	// Deprecated: Use Meta.PreviewFrameImage. Only filled in by Decode.
 	PreviewFrameImage image.Image
+	// PreviewFrame is a copy of Meta.PreviewFrame, kept for older callers.
+	//
+	// Deprecated: Use Meta.PreviewFrame. Only filled in by Decode.
 	PreviewFrame int
+	// Size is a copy of Meta.AnimationSize, kept for older callers.
+	//
+	// Deprecated: Use Meta.AnimationSize. Only filled in by Decode.
 	Size int
+	Loop bool
+	Layer1Hidden bool
+	Layer2Hidden bool
 }
 type Frame struct {
 	FrameImage image.Image
+	Layers *FrameLayers // Set for frames built in memory, which are used instead of the decoded frame data
 }
-type unpackedFrame struct {
-	Frame [2][192][256]byte
-	FrameOffset uint32
-	IsNewFrame bool
+
+// FrameLayers is a decoded frame before it is rendered: two 256x192 layers and the frame header fields
+type FrameLayers struct {
+	Layers [2][192][256]byte // The pen colour of every pixel, or 0 where the layer has no ink
+	IsNewFrame bool // Keyframe; when false the layers were stored as a diff against the previous frame
 	IsTranslated bool
 	TranslateX int
 	TranslateY int
-	PaperColor byte
-	PenColor []byte
-	HasFrame bool
+	PaperColor PaperColor
+	PenColor [2]PenColor
 }
 
+type PaperColor byte
+const (
+	PaperBlack PaperColor = 0x0
+	PaperWhite PaperColor = 0x1
+)
+
+type PenColor byte
+const (
+	PenInverse PenColor = 0x1 // The opposite of the paper colour, also used for 0x0
+	PenRed PenColor = 0x2
+	PenBlue PenColor = 0x3
+)
+
 type Offset struct {
 	Offset uint32
 	Length int
@@ -131,11 +166,17 @@ type Offset struct {
 
 type SoundData struct {
 	SoundMeta SoundMeta
-	BGM []int // PCM audio
-	SoundEffect1 []int // PCM audio
-	SoundEffect2 []int // PCM audio
-	SoundEffect3 []int // PCM audio
+	BGM []int16 // PCM audio
+	SoundEffect1 []int16 // PCM audio
+	SoundEffect2 []int16 // PCM audio
+	SoundEffect3 []int16 // PCM audio
+	SoundEffectFlags [][3]byte // Per frame, 1 where SoundEffect1, SoundEffect2 or SoundEffect3 starts playing
+	// Size is a copy of Meta.AudioSize, kept for older callers.
+	//
+	// Deprecated: Use Meta.AudioSize. Only filled in by Decode.
 	Size int
+
+	adpcm [4][]byte // Each track as it was stored, indexed by Track, so unchanged tracks are encoded exactly as they were
 }
 type SoundMeta struct {
 	BGM Offset
@@ -152,221 +193,192 @@ func debugLog(msg string) {
 	}
 }
 
+// Decode parses a PPM from r, which must hold size bytes of PPM data. The PPM keeps r to decode
+// frames on demand in Frame and FrameLayers, so r must stay readable and unchanged for as long
+// as the PPM is used.
+func Decode(r io.ReaderAt, size int64, opts *OpenConfig) (*PPM, error) {
+	ppmData := &PPM{OpenConfig: opts}
+	if err := ppmData.decode(r, size); err != nil {
+		return nil, err
+	}
+	return ppmData, nil
+}
+
+// DecodeBytes parses a PPM held entirely in memory. data is kept, as with Decode, so it must not
+// be modified while the PPM is in use.
+func DecodeBytes(data []byte) (*PPM, error) {
+	return Decode(bytes.NewReader(data), int64(len(data)), nil)
+}
+
 func (ppmData *PPM) Open() (error) {
-	ppmFile, err := os.Open(ppmData.FileLocation)
+	// The whole file is kept in memory so frames can still be decoded on demand after Open returns
+	ppmFile, err := os.ReadFile(ppmData.FileLocation)
 	if err != nil {
 		return err
 	}
-	defer ppmFile.Close()
-	
-	magic := make([]byte, 4)
-	ppmFile.ReadAt(magic, 0x0)
-	if !bytes.Equal(ppmMagic, magic) {
-		return errors.New("PPM magic incorrect")
-	}
 
-	audioSize := make([]byte, 4)
-	ppmFile.ReadAt(audioSize, 0x8)
-	ppmData.SoundData.Size = hex2int(binaryReadLE(audioSize))
+	return ppmData.decode(bytes.NewReader(ppmFile), int64(len(ppmFile)))
+}
 
-	lockStatus := make([]byte, 2)
-	ppmFile.ReadAt(lockStatus, 0x10)
-	if hex2int(lockStatus) > 0 {
-		ppmData.Locked = true
-	} else {
-		ppmData.Locked = false
-	}
+func (ppmData *PPM) decode(r io.ReaderAt, size int64) (error) {
+	ppmReader := io.NewSectionReader(r, 0, size)
+	ppmData.reader = ppmReader
+	ppmData.keyframes = nil
+	ppmData.frameCache = frameCache{}
 
-	originalAuthorName := make([]byte, 22)
-	ppmFile.ReadAt(originalAuthorName, 0x14)
-	ppmData.OriginalAuthorName = hex2string(originalAuthorName, true)
-
-	lastEditedAuthorName := make([]byte, 22)
-	ppmFile.ReadAt(lastEditedAuthorName, 0x2A)
-	ppmData.LastEditedAuthorName = hex2string(lastEditedAuthorName, true)
-
-	authorName := make([]byte, 22)
-	ppmFile.ReadAt(authorName, 0x40)
-	ppmData.AuthorName = hex2string(authorName, true)
-
-	originalAuthorIDBytes := make([]byte, 8)
-	ppmFile.ReadAt(originalAuthorIDBytes, 0x56)
-	originalAuthorID := binaryReadLE(originalAuthorIDBytes)
-	ppmData.OriginalAuthorID = strings.ToUpper(hexAsString(originalAuthorID))
-	regexpIDMatch, _ := regexp.MatchString(regexID, ppmData.OriginalAuthorID)
-	if !regexpIDMatch { return errors.New("Original author ID is not valid") }
-
-	lastEditedAuthorIDBytes := make([]byte, 8)
-	ppmFile.ReadAt(lastEditedAuthorIDBytes, 0x5E)
-	lastEditedAuthorID := binaryReadLE(lastEditedAuthorIDBytes)
-	ppmData.LastEditedAuthorID = strings.ToUpper(hexAsString(lastEditedAuthorID))
-	regexpIDMatch, _ = regexp.MatchString(regexID, ppmData.LastEditedAuthorID)
-	if !regexpIDMatch { return errors.New("Last edited author ID is not valid") }
-	
-	previousEditingAuthorIDBytes := make([]byte, 8)
-	ppmFile.ReadAt(previousEditingAuthorIDBytes, 0x8A)
-	previousEditingAuthorID := binaryReadLE(previousEditingAuthorIDBytes)
-	ppmData.PreviousEditingAuthorID = strings.ToUpper(hexAsString(previousEditingAuthorID))
-	regexpIDMatch, _ = regexp.MatchString(regexID, ppmData.PreviousEditingAuthorID)
-	if !regexpIDMatch { return errors.New("Previous editing author ID is not valid") }
-
-	originalFileName1 := make([]byte, 3)
-	originalFileName2 := make([]byte, 13)
-	originalFileName3 := make([]byte, 2)
-	ppmFile.ReadAt(originalFileName1, 0x66)
-	ppmFile.ReadAt(originalFileName2, 0x69)
-	ppmFile.ReadAt(originalFileName3, 0x7C)
-
-	ppmData.OriginalFileName = strings.ToUpper(hexAsString(originalFileName1) + "_" + hex2string(originalFileName2, false) + "_" + padLeft(strconv.Itoa(hex2int(originalFileName3)), "0", 3))
-	regexpFileNameMatch, _ := regexp.MatchString(regexFileName, ppmData.OriginalFileName)
-	if !regexpFileNameMatch { return errors.New("Original file name is not valid") }
-
-	fileName1 := make([]byte, 3)
-	fileName2 := make([]byte, 13)
-	fileName3 := make([]byte, 2)
-	ppmFile.ReadAt(fileName1, 0x78)
-	ppmFile.ReadAt(fileName2, 0x7B)
-	ppmFile.ReadAt(fileName3, 0x8E)
-		
-	ppmData.FileName = strings.ToUpper(hexAsString(fileName1) + "_" + hex2string(fileName2, false) + "_" + padLeft(strconv.Itoa(hex2int(fileName3)), "0", 3))
-	regexpFileNameMatch, _ = regexp.MatchString(regexFileName, ppmData.FileName)
-	if !regexpFileNameMatch { return errors.New("File name is not valid") }
-
-	partialFileName := make([]byte, 8)
-	ppmFile.ReadAt(partialFileName, 0x92)
-	ppmData.PartialFileName = hex2string(partialFileName, false)
-
-	date := make([]byte, 4)
-	ppmFile.ReadAt(date, 0x9A)
-	ppmData.Date = (hex2int64(date) + 946684800)
-
-	animationSize := make([]byte, 4)
-	ppmFile.ReadAt(animationSize, 0x4)
-	ppmData.FrameData.Size = int(binaryReadLE_uint32(animationSize))
-
-	frameCountBytes := make([]byte, 2)
-	ppmFile.ReadAt(frameCountBytes, 0xC)
-	frameCount := int(binaryReadLE_uint16(frameCountBytes)) + 1
-	if frameCount > 999 {
-		ppmData.FrameData.FrameCount = 999
-	} else {
-		ppmData.FrameData.FrameCount = frameCount
+	openConfig := ppmData.OpenConfig
+	if openConfig == nil {
+		openConfig = &OpenConfig{}
 	}
 
-	previewFrameN := make([]byte, 2)
-	ppmFile.ReadAt(previewFrameN, 0x12)
-	ppmData.FrameData.PreviewFrame = int(binaryReadLE_uint8(previewFrameN))
-
-	previewBitmap := make([]byte, 1536)
-	ppmFile.ReadAt(previewBitmap, 0xA0)
-	ppmData.FrameData.PreviewFrameBitmap = previewBitmap
-			
-	previewImage := image.NewRGBA(image.Rect(0, 0, 64, 48))
-	for tileY := 0; tileY < 6; tileY++ {
-		for tileX := 0; tileX < 8; tileX++ {
-			for imageY := 0; imageY < 8; imageY++ {
-				for imageX := 0; imageX < 8; imageX += 2 {
-					colorLoc := (tileY * 512 + tileX * 64 + imageY * 8 + imageX) / 2
-					colorByte := previewBitmap[colorLoc]
-					color1 := singleHex2int(colorByte & 0xF)
-					color2 := singleHex2int(colorByte >> 4)
-					rgbaColor1 := thumbnailPalette[color1]
-					rgbaColor2 := thumbnailPalette[color2]
-					previewImage.Set(imageX + tileX * 8, imageY + tileY * 8, rgbaColor1)
-					previewImage.Set(imageX + tileX * 8 + 1, imageY + tileY * 8, rgbaColor2)
-				}
-			}
+	if err := ppmData.Meta.decode(ppmReader, openConfig); err != nil {
+		return err
+	}
+	// Copies of the header kept for older callers; nothing in this package reads them
+	ppmData.FrameData.Size = ppmData.AnimationSize
+	ppmData.FrameData.FrameCount = ppmData.FrameCount
+	ppmData.FrameData.PreviewFrame = ppmData.PreviewFrame
+	ppmData.FrameData.PreviewFrameBitmap = ppmData.PreviewFrameBitmap
+	ppmData.FrameData.PreviewFrameImage = ppmData.PreviewFrameImage
+	ppmData.SoundData.Size = ppmData.AudioSize
+
+	// The animation size and frame count are still needed to locate the frame and sound sections.
+	// Frame data needs the sound header too, since the frame speed is kept there.
+	needFrameData := !openConfig.SkipFrameData
+	needAudioData := !openConfig.SkipAudioData
+
+	animationSize := ppmData.AnimationSize
+	if openConfig.SkipAnimationSize && (needFrameData || needAudioData) {
+		var err error
+		animationSize, err = decodeAnimationSize(ppmReader)
+		if err != nil {
+			return err
+		}
+	}
+	frameCount := ppmData.FrameCount
+	if openConfig.SkipFrameCount && (needFrameData || needAudioData) {
+		var err error
+		frameCount, err = decodeFrameCount(ppmReader)
+		if err != nil {
+			return err
 		}
 	}
-	ppmData.FrameData.PreviewFrameImage = previewImage
 
-	if ppmData.FrameData.FrameCount > 0 {
-		ppmData.FrameData.Frames = make([]Frame, ppmData.FrameData.FrameCount)
-		
-		ppmFile.Seek(0x06A0, 0) // Jump to the start of the animation data section
-		offsetTableLengthBytes := make([]byte, 2) // Make a byte array to store the offset table length
-		ppmFile.Read(offsetTableLengthBytes) // Read the offset table length into the byte array
+	if needFrameData && frameCount > 0 {
+		offsetTableLengthBytes, err := readAt(ppmReader, "OffsetTableLength", 0x06A0, 2) // Read the offset table length from the start of the animation data section
+		if err != nil {
+			return err
+		}
 		offsetTableLength := binaryReadLE_uint16(offsetTableLengthBytes) // Get the uint16 representation of the byte array
 				
 		debugLog("Offset table length: " + strconv.Itoa(int(offsetTableLength)))
+		if int(offsetTableLength) < frameCount * 4 || 8 + int(offsetTableLength) > animationSize {
+			return &FormatError{Field: "OffsetTableLength", Offset: 0x06A0, Err: ErrBadOffsetTable}
+		}
 				
-		ppmFile.Seek(0x06A8, 0) // Skip padding and unknown
+		animationFlagsBytes, err := readAt(ppmReader, "AnimationFlags", 0x06A6, 2) // Skip the padding before the animation flags
+		if err != nil {
+			return err
+		}
+		animationFlags := binaryReadLE_uint16(animationFlagsBytes)
+		ppmData.FrameData.Loop = (animationFlags >> 1) & 0x1 > 0
+		ppmData.FrameData.Layer1Hidden = animationFlags & 0x10 > 0
+		ppmData.FrameData.Layer2Hidden = animationFlags & 0x20 > 0
+
+		ppmReader.Seek(0x06A8, 0) // Jump to the frame offset table
 		
 		// Read frame offsets and build them into an array of frame offsets
-		frameOffsetsSize := ppmData.FrameData.FrameCount // Get the size of the frame offset array
+		frameOffsetsSize := frameCount // Get the size of the frame offset array
 		debugLog("Frame offsets array size: " + strconv.Itoa(int(frameOffsetsSize)))
 		frameOffsets := make([]uint32, frameOffsetsSize) // Create the frame offset array and set its value type to uint32
 		for frameOffsetN := 0; frameOffsetN < int(frameOffsetsSize); frameOffsetN++ { // Loop through the frame offset array
-			frameOffsetBytes := make([]byte, 4) // Make a byte array to store the frame offset
-			ppmFile.Read(frameOffsetBytes) // Read the frame offset (relative to the end of the offset table) into the byte array
-			frameOffsets[frameOffsetN] = uint32(0x06A8 + offsetTableLength) + binaryReadLE_uint32(frameOffsetBytes) // Store the frame offset (relative to the beginning of the file) in the frame offset array
+			frameOffsetBytes, err := readNext(ppmReader, "FrameOffsets", 4) // Read the frame offset (relative to the end of the offset table)
+			if err != nil {
+				return err
+			}
+			frameOffsets[frameOffsetN] = 0x06A8 + uint32(offsetTableLength) + binaryReadLE_uint32(frameOffsetBytes) // Store the frame offset (relative to the beginning of the file) in the frame offset array
+			if int64(frameOffsets[frameOffsetN]) >= size {
+				return &FormatError{Field: "FrameOffsets", Offset: int64(0x06A8 + frameOffsetN * 4), Err: ErrBadFrameOffset}
+			}
 			debugLog("Frame " + strconv.Itoa(frameOffsetN) + " offset: " + fmt.Sprintf("%v", frameOffsets[frameOffsetN]))
 		}
 		ppmData.FrameData.FrameOffsets = frameOffsets
-				
-		currentFrame := &unpackedFrame{}
-		prevFrame := &unpackedFrame{}
-		for frameN := 0; frameN < int(frameOffsetsSize); frameN++ {
-			debugLog("> Parsing frame " + strconv.Itoa(frameN) + " out of " + strconv.Itoa(ppmData.FrameData.FrameCount) + "...")
-			
-			prevFrame = currentFrame
-			currentFrame = decodeFrame(ppmFile, ppmData, frameN, &unpackedFrame{})
-			if !currentFrame.IsNewFrame {
-				for line := 0; line < 192; line++ {
-					for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
-						for layer := 0; layer < 2; layer++ {
-							currentFrame.Frame[layer][line][pixelPosition] = currentFrame.Frame[layer][line][pixelPosition]^prevFrame.Frame[layer][line][pixelPosition]
-						}
-					}
+
+		ppmData.keyframes, err = buildKeyframeIndex(ppmReader, frameOffsets)
+		if err != nil {
+			return err
+		}
+
+		if !openConfig.SkipFrames {
+			ppmData.FrameData.Frames = make([]Frame, frameCount)
+			for frameN := 0; frameN < frameCount; frameN++ {
+				debugLog("> Parsing frame " + strconv.Itoa(frameN) + " out of " + strconv.Itoa(frameCount) + "...")
+
+				frame, err := ppmData.Frame(frameN)
+				if err != nil {
+					return err
 				}
+				ppmData.FrameData.Frames[frameN] = *frame
 			}
-					
-			frameImage := getFrameImage(currentFrame, ppmFile, ppmData, frameN)
-			ppmData.FrameData.Frames[frameN].FrameImage = frameImage
 		}
 	}
 
-	debugLog("> Decoding sound header...")
-	decodeSoundHeader(ppmFile, ppmData)
-	debugLog("> Decoding BGM...")
-	ppmData.SoundData.BGM = decodeAudio(ppmFile, ppmData, ppmData.SoundData.SoundMeta.BGM.Offset, ppmData.SoundData.SoundMeta.BGM.Length)
-	debugLog("> Decoding SoundEffect1...")
-	ppmData.SoundData.SoundEffect1 = decodeAudio(ppmFile, ppmData, ppmData.SoundData.SoundMeta.SoundEffect1.Offset, ppmData.SoundData.SoundMeta.SoundEffect1.Length)
-	debugLog("> Decoding SoundEffect2...")
-	ppmData.SoundData.SoundEffect2 = decodeAudio(ppmFile, ppmData, ppmData.SoundData.SoundMeta.SoundEffect2.Offset, ppmData.SoundData.SoundMeta.SoundEffect2.Length)
-	debugLog("> Decoding SoundEffect3...")
-	ppmData.SoundData.SoundEffect3 = decodeAudio(ppmFile, ppmData, ppmData.SoundData.SoundMeta.SoundEffect3.Offset, ppmData.SoundData.SoundMeta.SoundEffect3.Length)
+	if needFrameData || needAudioData {
+		debugLog("> Decoding sound header...")
+		if err := decodeSoundHeader(ppmReader, ppmData, animationSize, frameCount); err != nil {
+			return err
+		}
+	}
+
+	if needAudioData {
+		var err error
+		debugLog("> Decoding sound effect flags...")
+		ppmData.SoundData.SoundEffectFlags, err = decodeSoundFlags(ppmReader, animationSize, frameCount)
+		if err != nil {
+			return err
+		}
+		debugLog("> Decoding BGM...")
+		ppmData.SoundData.BGM, err = decodeAudio(ppmReader, ppmData, TrackBGM, ppmData.SoundData.SoundMeta.BGM.Offset, ppmData.SoundData.SoundMeta.BGM.Length)
+		if err != nil {
+			return err
+		}
+		debugLog("> Decoding SoundEffect1...")
+		ppmData.SoundData.SoundEffect1, err = decodeAudio(ppmReader, ppmData, TrackSoundEffect1, ppmData.SoundData.SoundMeta.SoundEffect1.Offset, ppmData.SoundData.SoundMeta.SoundEffect1.Length)
+		if err != nil {
+			return err
+		}
+		debugLog("> Decoding SoundEffect2...")
+		ppmData.SoundData.SoundEffect2, err = decodeAudio(ppmReader, ppmData, TrackSoundEffect2, ppmData.SoundData.SoundMeta.SoundEffect2.Offset, ppmData.SoundData.SoundMeta.SoundEffect2.Length)
+		if err != nil {
+			return err
+		}
+		debugLog("> Decoding SoundEffect3...")
+		ppmData.SoundData.SoundEffect3, err = decodeAudio(ppmReader, ppmData, TrackSoundEffect3, ppmData.SoundData.SoundMeta.SoundEffect3.Offset, ppmData.SoundData.SoundMeta.SoundEffect3.Length)
+		if err != nil {
+			return err
+		}
+	}
 	
 	debugLog("> Finished decoding PPM")
 	ppmData.Success = true
 	return nil
 }
 
-func decodeSoundHeader(ppmFile *os.File, ppmData *PPM) {
-	soundHeaderOffset := 0x06A0 + ppmData.FrameData.Size + ppmData.FrameData.FrameCount
+func decodeSoundHeader(ppmReader *io.SectionReader, ppmData *PPM, animationSize int, frameCount int) (error) {
+	soundHeaderOffset := 0x06A0 + animationSize + frameCount
 	if (soundHeaderOffset % 4) != 0 { soundHeaderOffset += 4 - (soundHeaderOffset % 4) }
-	ppmFile.Seek(int64(soundHeaderOffset), 0)
-	
-	bgmSizeBytes := make([]byte, 4)
-	sec1SizeBytes := make([]byte, 4)
-	sec2SizeBytes := make([]byte, 4)
-	sec3SizeBytes := make([]byte, 4)
-	ppmFile.Read(bgmSizeBytes)
-	ppmFile.Read(sec1SizeBytes)
-	ppmFile.Read(sec2SizeBytes)
-	ppmFile.Read(sec3SizeBytes)
-	bgmSize := binaryReadLE_uint32(bgmSizeBytes)
-	sec1Size := binaryReadLE_uint32(sec1SizeBytes)
-	sec2Size := binaryReadLE_uint32(sec2SizeBytes)
-	sec3Size := binaryReadLE_uint32(sec3SizeBytes)
-
-	frameSpeedBytes := make([]byte, 2)
-	ppmFile.Read(frameSpeedBytes)
-	frameSpeed := 8 - binaryReadLE_uint8(frameSpeedBytes)
-
-	bgmSpeedBytes := make([]byte, 2)
-	ppmFile.Read(bgmSpeedBytes)
-	bgmSpeed := 8 - binaryReadLE_uint8(bgmSpeedBytes)
+
+	soundHeader, err := readAt(ppmReader, "SoundHeader", int64(soundHeaderOffset), 32)
+	if err != nil {
+		return err
+	}
+	bgmSize := binaryReadLE_uint32(soundHeader[0:4])
+	sec1Size := binaryReadLE_uint32(soundHeader[4:8])
+	sec2Size := binaryReadLE_uint32(soundHeader[8:12])
+	sec3Size := binaryReadLE_uint32(soundHeader[12:16])
+
+	frameSpeed := 8 - binaryReadLE_uint8(soundHeader[16:17])
+	bgmSpeed := 8 - binaryReadLE_uint8(soundHeader[17:18]) // The frame speed the BGM was recorded at
 
 	ppmData.SoundData.SoundMeta.FrameSpeed = int(frameSpeed)
 	ppmData.SoundData.SoundMeta.BGMSpeed = int(bgmSpeed)
@@ -383,44 +395,47 @@ func decodeSoundHeader(ppmFile *os.File, ppmData *PPM) {
 	soundHeaderOffset += int(sec2Size)
 	ppmData.SoundData.SoundMeta.SoundEffect3.Offset = uint32(soundHeaderOffset)
 	ppmData.SoundData.SoundMeta.SoundEffect3.Length = int(sec3Size)
+	return nil
 }
 
-func decodeAudio(ppmFile *os.File, ppmData *PPM, trackOffset uint32, trackLength int) []int {
+func decodeAudio(ppmReader *io.SectionReader, ppmData *PPM, track Track, trackOffset uint32, trackLength int) ([]int16, error) {
 	debugLog("> Decoding track at offset " + strconv.Itoa(int(trackOffset)) + " with length " + strconv.Itoa(trackLength))
 
-	ppmFile.Seek(int64(trackOffset), 0)
+	if trackLength < 0 || int64(trackOffset) + int64(trackLength) > ppmReader.Size() {
+		return nil, &FormatError{Field: "SoundTrack", Offset: int64(trackOffset), Err: ErrBadTrackOffset}
+	}
+	buffer, err := readAt(ppmReader, "SoundTrack", int64(trackOffset), trackLength)
+	if err != nil {
+		return nil, err
+	}
+	ppmData.SoundData.adpcm[track] = buffer
+	return DecodeADPCM(buffer), nil
+}
 
-	buffer := make([]byte, trackLength)
-	ppmFile.Read(buffer)
-	for i := 0; i < trackLength; i++ {
-		buffer[i] = (buffer[i] & 0xF) << 4 | (buffer[i] >> 4) // Flipnote Studio's adpcm data uses reverse nibble order
+// decodeSoundFlags reads the byte per frame that follows the animation data and says which sound effects start on that frame
+func decodeSoundFlags(ppmReader *io.SectionReader, animationSize int, frameCount int) ([][3]byte, error) {
+	soundFlagsBytes, err := readAt(ppmReader, "SoundFlags", int64(0x06A0 + animationSize), frameCount)
+	if err != nil {
+		return nil, err
 	}
-	audio := make([]int, 0)
-	decoder := adpcm.NewDecoder(1)
-	decoder.Decode(buffer, &audio)
-	return audio
-}
-
-func decodeSoundFlags(ppmFile *os.File, ppmData *PPM) [][3]byte {
-	ppmFile.Seek(int64(0x06A0 + ppmData.FrameData.Size), 0)
-	array := make([][3]byte, ppmData.FrameData.FrameCount)
-	for i := 0; i < ppmData.FrameData.FrameCount; i++ {
-		newByteBytes := make([]byte, 2)
-		ppmFile.Read(newByteBytes)
-		newByte := binaryReadLE_uint8(newByteBytes)
+	array := make([][3]byte, frameCount)
+	for i := 0; i < frameCount; i++ {
+		newByte := soundFlagsBytes[i]
 		array[i][0] = newByte & 0x1
 		array[i][1] = (newByte >> 1) & 0x1
 		array[i][2] = (newByte >> 2) & 0x1
 	}
-	return array
+	return array, nil
 }
 
-func decodeFrame(ppmFile *os.File, ppmData *PPM, frameN int, prevFrame *unpackedFrame) *unpackedFrame {
+func decodeFrame(ppmReader *io.SectionReader, ppmData *PPM, frameN int) (*FrameLayers, error) {
 	frameOffset := ppmData.FrameData.FrameOffsets[frameN]
-	ppmFile.Seek(int64(frameOffset), 0) // Jump to the current frame
+	ppmReader.Seek(int64(frameOffset), 0) // Jump to the current frame
 	
-	frameHeaderBytes := make([]byte, 1)
-	ppmFile.Read(frameHeaderBytes)
+	frameHeaderBytes, err := readNext(ppmReader, "FrameHeader", 1)
+	if err != nil {
+		return nil, err
+	}
 	frameHeader := uint(frameHeaderBytes[0])
 	isNewFrame := false
 	if ((frameHeader >> 7) & 0x1) > 0 { isNewFrame = true }
@@ -429,23 +444,30 @@ func decodeFrame(ppmFile *os.File, ppmData *PPM, frameN int, prevFrame *unpacked
 	translateX := 0
 	translateY := 0
 	if isTranslated {
-		translateXBytes := make([]byte, 1)
-		translateYBytes := make([]byte, 1)
-		ppmFile.Read(translateXBytes)
-		ppmFile.Read(translateYBytes)
-		translateX = int(translateXBytes[0])
-		translateY = int(translateYBytes[0])
+		translateBytes, err := readNext(ppmReader, "FrameTranslation", 2)
+		if err != nil {
+			return nil, err
+		}
+		translateX = int(int8(translateBytes[0])) // Both offsets are signed
+		translateY = int(int8(translateBytes[1]))
+	}
+	paperColor := PaperColor(frameHeader & 0x1)
+	penColor := [2]PenColor{}
+	penColor[0] = PenColor((frameHeader >> 1) & 0x3)
+	penColor[1] = PenColor((frameHeader >> 3) & 0x3)
+	for layer := 0; layer < 2; layer++ {
+		if penColor[layer] == 0x0 {
+			penColor[layer] = PenInverse // 0x0 draws the same as 0x1, and would otherwise be indistinguishable from no ink
+		}
 	}
-	paperColor := byte(frameHeader & 0x1)
-	penColor := make([]byte, 2)
-	penColor[0] = byte((frameHeader >> 1) & 0x3)
-	penColor[1] = byte((frameHeader >> 3) & 0x3)
 	
-	layer1LineEncodingsBytes := make([]byte, 48)
-	layer2LineEncodingsBytes := make([]byte, 48)
+	lineEncodingsBytes, err := readNext(ppmReader, "LineEncodings", 96)
+	if err != nil {
+		return nil, err
+	}
+	layer1LineEncodingsBytes := lineEncodingsBytes[:48]
+	layer2LineEncodingsBytes := lineEncodingsBytes[48:]
 	layerLineEncodings := [2][192]uint{}
-	ppmFile.Read(layer1LineEncodingsBytes)
-	ppmFile.Read(layer2LineEncodingsBytes)
 	for byteOffset := 0; byteOffset < 48; byteOffset++ {
 		layer1LineEncoding := uint(layer1LineEncodingsBytes[byteOffset])
 		layer2LineEncoding := uint(layer2LineEncodingsBytes[byteOffset])
@@ -466,193 +488,120 @@ func decodeFrame(ppmFile *os.File, ppmData *PPM, frameN int, prevFrame *unpacked
 				case 0:
 					continue
 				case 1:
-					lineHeaderBytes := make([]byte, 4)
-					ppmFile.Read(lineHeaderBytes)
+					lineHeaderBytes, err := readNext(ppmReader, "LineHeader", 4)
+					if err != nil {
+						return nil, err
+					}
 					lineHeader := hex2uint32(lineHeaderBytes)
 					
 					pixelPosition := 0
 					for (lineHeader & 0xFFFFFFFF > 0) {
 						if (lineHeader & 0x80000000 > 0) {
-							chunkByte := make([]byte, 1)
-							ppmFile.Read(chunkByte)
-							chunkByteInt := uint(chunkByte[0])
-							for loop := 0; loop < 8; loop++ {
-								if (chunkByteInt & 0x1) == 1 {
-									frame[layer][line][pixelPosition] = penColor[layer]
-								}
-								pixelPosition += 1
-								chunkByteInt = chunkByteInt >> 1
+							chunkByte, err := readNext(ppmReader, "LineData", 1)
+							if err != nil {
+								return nil, err
 							}
+							unpackLineChunk(&frame[layer][line], pixelPosition, chunkByte[0], byte(penColor[layer]))
+							pixelPosition += 8
 						} else {
 							pixelPosition += 8
 						}
 						lineHeader = lineHeader << 1
 					}
 				case 2:
-					lineHeaderBytes := make([]byte, 4)
-					ppmFile.Read(lineHeaderBytes)
+					lineHeaderBytes, err := readNext(ppmReader, "LineHeader", 4)
+					if err != nil {
+						return nil, err
+					}
 					lineHeader := hex2uint32(lineHeaderBytes)
 					
 					for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
-						frame[layer][line][pixelPosition] = penColor[layer]
+						frame[layer][line][pixelPosition] = byte(penColor[layer])
 					}
 					
 					pixelPosition := 0
 					for (lineHeader & 0xFFFFFFFF > 0) {
 						if (lineHeader & 0x80000000 > 0) {
-							chunkByte := make([]byte, 1)
-							ppmFile.Read(chunkByte)
-							chunkByteInt := uint(chunkByte[0])
-							for loop := 0; loop < 8; loop++ {
-								if (chunkByteInt & 0x1) == 0 {
-									frame[layer][line][pixelPosition] = paperColor
-								}
-								pixelPosition += 1
-								chunkByteInt = chunkByteInt >> 1
+							chunkByte, err := readNext(ppmReader, "LineData", 1)
+							if err != nil {
+								return nil, err
 							}
+							unpackLineChunk(&frame[layer][line], pixelPosition, chunkByte[0], byte(penColor[layer]))
+							pixelPosition += 8
 						} else {
 							pixelPosition += 8
 						}
 						lineHeader = lineHeader << 1
 					}
 				case 3:
-					lineDataBytes := make([]byte, 32)
-					ppmFile.Read(lineDataBytes)
+					lineDataBytes, err := readNext(ppmReader, "LineData", 32)
+					if err != nil {
+						return nil, err
+					}
 					
-					pixelPosition := 0
+					// Raw lines are every chunk of the line with no chunk header
 					for lineDataIndex := 0; lineDataIndex < 32; lineDataIndex++ {
-						chunkByte := uint(lineDataBytes[lineDataIndex])
-						for loop := 0; loop < 8; loop++ {
-							if (chunkByte & 0x1) == 1 {
-								frame[layer][line][pixelPosition] = byte(chunkByte >> uint(pixelPosition) & 0x1)
-							}
-							pixelPosition += 1
-							chunkByte = chunkByte >> 1
-						}
+						unpackLineChunk(&frame[layer][line], lineDataIndex * 8, lineDataBytes[lineDataIndex], byte(penColor[layer]))
 					}
 			}
 		}
 	}
 	
-	unpackedFrame := &unpackedFrame{Frame:frame,FrameOffset:frameOffset,IsNewFrame:isNewFrame,IsTranslated:isTranslated,TranslateX:translateX,TranslateY:translateY,PaperColor:paperColor,PenColor:penColor,HasFrame:true}
-	return unpackedFrame
+	frameLayers := &FrameLayers{Layers:frame,IsNewFrame:isNewFrame,IsTranslated:isTranslated,TranslateX:translateX,TranslateY:translateY,PaperColor:paperColor,PenColor:penColor}
+	return frameLayers, nil
 }
 
-func decodePrevFrames(ppmFile *os.File, ppmData *PPM, frameN int) *unpackedFrame {
-	backTrack := 0
-	isNewFrame := true
-	for !isNewFrame {
-		backTrack += 1
-		backTrackFrame := decodeFrame(ppmFile, ppmData, frameN - backTrack, &unpackedFrame{})
-		isNewFrame = backTrackFrame.IsNewFrame
-	}
-	backTrack = frameN - backTrack
-	backTrackFrame := &unpackedFrame{}
-	for backTrack < frameN {
-		backTrackFrame = decodeFrame(ppmFile, ppmData, backTrack, backTrackFrame)
-		backTrack += 1
+// unpackLineChunk writes the 8 pixels packed into chunkByte, least significant bit first, as either the pen colour or no ink
+func unpackLineChunk(line *[256]byte, pixelPosition int, chunkByte byte, penColor byte) {
+	for bit := 0; bit < 8; bit++ {
+		if (chunkByte >> uint(bit)) & 0x1 == 1 {
+			line[pixelPosition + bit] = penColor
+		} else {
+			line[pixelPosition + bit] = 0
+		}
 	}
-	return backTrackFrame
 }
 
-func getFrameImage(decodedFrame *unpackedFrame, ppmFile *os.File, ppmData *PPM, frameN int) image.Image {
-	frame := decodedFrame.Frame
-	isNewFrame := decodedFrame.IsNewFrame
-	isTranslated := decodedFrame.IsTranslated
-	translateX := decodedFrame.TranslateX
-	translateY := decodedFrame.TranslateY
+// getFrameImage renders a composited frame scaled up scale times, leaving out hidden layers; translation has already been applied by applyFrameDiff
+func getFrameImage(decodedFrame *FrameLayers, layerHidden [2]bool, scale int) *image.Paletted {
+	frame := decodedFrame.Layers
 	paperColor := decodedFrame.PaperColor
-	penColor := decodedFrame.PenColor
-	if !isNewFrame {
-		//prevDecodedFrame := decodeFrame(ppmFile, ppmData, frameN - 1)
-		//frameImage := getFrameImage(prevDecodedFrame, ppmFile, ppmData, frameN - 1).(*image.RGBA)
-		frameImage := image.NewRGBA(image.Rect(0, 0, 256, 192))
-		for line := 0; line < 256; line++ {
-			for pixelPosition := 0; pixelPosition < 192; pixelPosition++ {
-				y := line
-				x := pixelPosition
-				if isTranslated {
-					y := line + translateY
-					x := pixelPosition + translateX
-					if y > 256 { y -= 256 }
-					if x > 192 { x -= 192 }
+	if scale < 1 {
+		scale = 1
+	}
+
+	paperIndex := uint8(framePaletteBlack)
+	inverseIndex := uint8(framePaletteWhite)
+	if paperColor == PaperWhite {
+		paperIndex, inverseIndex = inverseIndex, paperIndex
+	}
+
+	frameImage := image.NewPaletted(image.Rect(0, 0, 256 * scale, 192 * scale), framePaletteColors)
+	for line := 0; line < 192; line++ {
+		for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
+			pixelIndex := paperIndex
+			for layer := 1; layer >= 0; layer-- { // Layer 1 is drawn on top of layer 2
+				if layerHidden[layer] {
+					continue
 				}
-				for layer := 1; layer >= 0; layer-- {
-					switch frame[layer][pixelPosition][line] {
-						case 0x0:
-							if frame[0][pixelPosition][line] == 0x1 {
-								if penColor[0] == 0x1 {
-									if paperColor == 0x0 {
-										frameImage.Set(y, x, framePalette["white"])
-									} else {
-										frameImage.Set(y, x, framePalette["black"])
-									}
-								} else if penColor[0] == 0x2 {
-									frameImage.Set(y, x, framePalette["red"])
-								} else if penColor[0] == 0x3 {
-									frameImage.Set(y, x, framePalette["blue"])
-								}
-							} else if frame[0][pixelPosition][line] == 0x0 && frame[1][pixelPosition][line] == 0x1 {
-								if penColor[1] == 0x1 {
-									if paperColor == 0x0 {
-										frameImage.Set(y, x, framePalette["white"])
-									} else {
-										frameImage.Set(y, x, framePalette["black"])
-									}
-								} else if penColor[1] == 0x2 {
-									frameImage.Set(y, x, framePalette["red"])
-								} else if penColor[1] == 0x3 {
-									frameImage.Set(y, x, framePalette["blue"])
-								}
-							} else {
-								if paperColor == 0x0 {
-									frameImage.Set(y, x, framePalette["black"])
-								} else {
-									frameImage.Set(y, x, framePalette["white"])
-								}
-							}
-						case 0x1:
-							if paperColor == 0x0 {
-								frameImage.Set(y, x, framePalette["white"])
-							} else {
-								frameImage.Set(y, x, framePalette["black"])
-							}
-						case 0x2:
-							frameImage.Set(y, x, framePalette["red"])
-						case 0x3:
-							frameImage.Set(y, x	, framePalette["blue"])
-					}
+				switch PenColor(frame[layer][line][pixelPosition]) {
+					case PenInverse:
+						pixelIndex = inverseIndex
+					case PenRed:
+						pixelIndex = framePaletteRed
+					case PenBlue:
+						pixelIndex = framePaletteBlue
 				}
 			}
-		}
-		return frameImage
-	} else {
-		frameImage := image.NewRGBA(image.Rect(0, 0, 256, 192))
-		for line := 0; line < 256; line++ {
-			for pixelPosition := 0; pixelPosition < 192; pixelPosition++ {
-				if paperColor == 0x0 {
-					frameImage.Set(line, pixelPosition, framePalette["black"])
-				} else {
-					frameImage.Set(line, pixelPosition, framePalette["white"])
-				}
-				for layer := 1; layer >= 0; layer-- {
-					switch frame[layer][pixelPosition][line] {
-						case 0x1:
-							if paperColor == 0x0 {
-								frameImage.Set(line, pixelPosition, framePalette["white"])
-							} else {
-								frameImage.Set(line, pixelPosition, framePalette["black"])
-							}
-						case 0x2:
-							frameImage.Set(line, pixelPosition, framePalette["red"])
-						case 0x3:
-							frameImage.Set(line, pixelPosition, framePalette["blue"])
-					}
+			for scaleY := 0; scaleY < scale; scaleY++ {
+				pixOffset := frameImage.PixOffset(pixelPosition * scale, line * scale + scaleY)
+				for scaleX := 0; scaleX < scale; scaleX++ {
+					frameImage.Pix[pixOffset + scaleX] = pixelIndex
 				}
 			}
 		}
-		return frameImage
 	}
+	return frameImage
 }
 
 func binaryReadLE(byteArray []byte) []byte {
@@ -728,10 +677,8 @@ func singleHexAsString(hexByte byte) string {
 	return hexStr
 }
 func padLeft(str, pad string, length int) string {
-	for {
+	for len(str) < length {
 		str = pad + str
-		if len(str) > length {
-			return str[0:length]
-		}
 	}
+	return str
 }
\ No newline at end of file
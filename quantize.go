@@ -0,0 +1,290 @@
+package ppm
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// DitherMode selects how Quantize spreads colours it can't show exactly
+type DitherMode int
+const (
+	DitherThreshold DitherMode = iota // Every pixel takes the closest colour
+	DitherFloydSteinberg
+	DitherAtkinson
+	DitherBayer // Ordered dithering with an 8x8 Bayer matrix
+)
+
+type QuantizeOptions struct {
+	Dither DitherMode
+	Contrast float64 // Stretches each channel away from mid grey by this factor, 1 if unset
+	Gamma float64 // Brightens the image above 1 and darkens it below, 1 if unset; applied before Contrast
+}
+
+// A colour as luma and two colour differences, R-Y and B-Y, each worked out from channels of 0 to 1.
+// Measuring distances this way keeps greys from being matched to red or blue just because they are
+// nearer to it than to black or white in RGB.
+type quantizeColor [3]float64
+
+// Extra error each pen has to save before Quantize uses it, so stray pixels don't pull in a colour
+const quantizePenCost = 0.002
+
+var bayerMatrix = [8][8]int{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// Quantize scales an image to 256x192 and reduces it to a frame: a paper colour and up to two pens.
+// Transparent pixels count as white. The paper and pens are picked per image as whichever of Flipnote's
+// colours can stand in for it best, given that dithering can mix them, and layer 1 gets the most used pen.
+func Quantize(img image.Image, opts QuantizeOptions) *FrameLayers {
+	pixels := samplePixels(img)
+	adjustPixels(pixels, opts.Contrast, opts.Gamma)
+	for y := 0; y < 192; y++ {
+		for x := 0; x < 256; x++ {
+			pixels[y][x] = rgbToQuantizeColor(pixels[y][x][0], pixels[y][x][1], pixels[y][x][2])
+		}
+	}
+
+	paperIndex, penIndexes := choosePalette(pixels, opts.Dither != DitherThreshold)
+	colors := []quantizeColor{frameColor(paperIndex)}
+	for _, penIndex := range penIndexes {
+		colors = append(colors, frameColor(penIndex))
+	}
+	colorIndexes := ditherPixels(pixels, colors, opts.Dither)
+
+	frameLayers := &FrameLayers{IsNewFrame: true, PenColor: [2]PenColor{PenInverse, PenInverse}}
+	if paperIndex == framePaletteWhite {
+		frameLayers.PaperColor = PaperWhite
+	}
+	penColors := map[uint8]PenColor{framePaletteBlack: PenInverse, framePaletteWhite: PenInverse, framePaletteRed: PenRed, framePaletteBlue: PenBlue}
+
+	// Layer 1 takes the more used pen
+	penCounts := [3]int{}
+	for y := 0; y < 192; y++ {
+		for x := 0; x < 256; x++ {
+			penCounts[colorIndexes[y][x]]++
+		}
+	}
+	colorLayers := [3]int{0, 1, 2} // 0 for the paper, or the layer a colour is drawn on plus one
+	if len(penIndexes) == 2 && penCounts[2] > penCounts[1] {
+		colorLayers = [3]int{0, 2, 1}
+	}
+	for colorIndex, penIndex := range penIndexes {
+		frameLayers.PenColor[colorLayers[colorIndex + 1] - 1] = penColors[penIndex]
+	}
+
+	for y := 0; y < 192; y++ {
+		for x := 0; x < 256; x++ {
+			if layer := colorLayers[colorIndexes[y][x]]; layer > 0 {
+				frameLayers.Layers[layer - 1][y][x] = byte(frameLayers.PenColor[layer - 1])
+			}
+		}
+	}
+	return frameLayers
+}
+
+// samplePixels scales an image to 256x192, averaging the pixels that fall into each one when it shrinks,
+// and blends it over white. The pixels are left as RGB.
+func samplePixels(img image.Image) *[192][256]quantizeColor {
+	pixels := &[192][256]quantizeColor{}
+	bounds := img.Bounds()
+	for y := 0; y < 192; y++ {
+		minY := bounds.Min.Y + y * bounds.Dy() / 192
+		maxY := bounds.Min.Y + (y + 1) * bounds.Dy() / 192
+		if maxY <= minY {
+			maxY = minY + 1
+		}
+		for x := 0; x < 256; x++ {
+			minX := bounds.Min.X + x * bounds.Dx() / 256
+			maxX := bounds.Min.X + (x + 1) * bounds.Dx() / 256
+			if maxX <= minX {
+				maxX = minX + 1
+			}
+			pixel := quantizeColor{}
+			for sourceY := minY; sourceY < maxY; sourceY++ {
+				for sourceX := minX; sourceX < maxX; sourceX++ {
+					red, green, blue, alpha := img.At(sourceX, sourceY).RGBA()
+					pixel[0] += float64(red + 0xFFFF - alpha)
+					pixel[1] += float64(green + 0xFFFF - alpha)
+					pixel[2] += float64(blue + 0xFFFF - alpha)
+				}
+			}
+			area := float64((maxX - minX) * (maxY - minY) * 0xFFFF)
+			pixels[y][x] = quantizeColor{pixel[0] / area, pixel[1] / area, pixel[2] / area}
+		}
+	}
+	return pixels
+}
+
+// adjustPixels applies gamma and then contrast to every channel of RGB pixels
+func adjustPixels(pixels *[192][256]quantizeColor, contrast float64, gamma float64) {
+	if contrast == 0 {
+		contrast = 1
+	}
+	if gamma <= 0 {
+		gamma = 1
+	}
+	if contrast == 1 && gamma == 1 {
+		return
+	}
+	for y := 0; y < 192; y++ {
+		for x := 0; x < 256; x++ {
+			for channel := 0; channel < 3; channel++ {
+				value := math.Pow(pixels[y][x][channel], 1 / gamma)
+				value = (value - 0.5) * contrast + 0.5
+				pixels[y][x][channel] = math.Max(0, math.Min(1, value))
+			}
+		}
+	}
+}
+
+// choosePalette picks the paper and the pens, from framePaletteColors, that leave the least error over
+// the image. When the image will be dithered, a pixel only counts as far as it is from the nearest mix
+// of two of the colours.
+func choosePalette(pixels *[192][256]quantizeColor, dithered bool) (uint8, []uint8) {
+	// Try the paper most of the image is closer to first, so it wins ties
+	lightPixels := 0
+	for y := 0; y < 192; y++ {
+		for x := 0; x < 256; x++ {
+			if pixels[y][x][0] >= 0.5 {
+				lightPixels++
+			}
+		}
+	}
+	paperIndexes := []uint8{framePaletteWhite, framePaletteBlack}
+	if lightPixels * 2 < 192 * 256 {
+		paperIndexes = []uint8{framePaletteBlack, framePaletteWhite}
+	}
+
+	bestPaper := paperIndexes[0]
+	bestPens := []uint8{}
+	bestScore := math.Inf(1)
+	for _, paperIndex := range paperIndexes {
+		inverseIndex := uint8(framePaletteBlack)
+		if paperIndex == framePaletteBlack {
+			inverseIndex = framePaletteWhite
+		}
+		for _, penIndexes := range [][]uint8{{}, {inverseIndex}, {framePaletteRed}, {framePaletteBlue},
+			{inverseIndex, framePaletteRed}, {inverseIndex, framePaletteBlue}, {framePaletteRed, framePaletteBlue}} {
+			colors := []quantizeColor{frameColor(paperIndex)}
+			for _, penIndex := range penIndexes {
+				colors = append(colors, frameColor(penIndex))
+			}
+			score := paletteError(pixels, colors, dithered) + quantizePenCost * float64(len(penIndexes))
+			if score < bestScore {
+				bestPaper, bestPens, bestScore = paperIndex, penIndexes, score
+			}
+		}
+	}
+	return bestPaper, bestPens
+}
+
+// paletteError returns the average squared distance from every other pixel to the closest colour, or
+// to the closest mix of two colours when dithered
+func paletteError(pixels *[192][256]quantizeColor, colors []quantizeColor, dithered bool) float64 {
+	totalError := 0.0
+	for y := 0; y < 192; y += 2 {
+		for x := 0; x < 256; x += 2 {
+			pixel := pixels[y][x]
+			pixelError := math.Inf(1)
+			for i := range colors {
+				pixelError = math.Min(pixelError, colorDistance(pixel, colors[i]))
+				for j := i + 1; dithered && j < len(colors); j++ {
+					pixelError = math.Min(pixelError, mixDistance(pixel, colors[i], colors[j]))
+				}
+			}
+			totalError += pixelError
+		}
+	}
+	return totalError / (96 * 128)
+}
+
+// ditherPixels maps every pixel to an index into colors
+func ditherPixels(pixels *[192][256]quantizeColor, colors []quantizeColor, mode DitherMode) *[192][256]uint8 {
+	colorIndexes := &[192][256]uint8{}
+	errorPixels := *pixels // Error diffusion works on a copy so the caller's pixels stay as they were
+	for y := 0; y < 192; y++ {
+		for x := 0; x < 256; x++ {
+			pixel := errorPixels[y][x]
+			if mode == DitherBayer {
+				pixel[0] += (float64(bayerMatrix[y % 8][x % 8]) + 0.5) / 64 - 0.5 // Brightening all of R, G and B only changes the luma
+			}
+			colorIndex := closestColor(pixel, colors)
+			colorIndexes[y][x] = uint8(colorIndex)
+
+			pixelError := quantizeColor{}
+			for channel := 0; channel < 3; channel++ {
+				pixelError[channel] = pixel[channel] - colors[colorIndex][channel]
+			}
+			switch mode {
+				case DitherFloydSteinberg:
+					diffuseError(&errorPixels, x + 1, y, pixelError, 7.0 / 16)
+					diffuseError(&errorPixels, x - 1, y + 1, pixelError, 3.0 / 16)
+					diffuseError(&errorPixels, x, y + 1, pixelError, 5.0 / 16)
+					diffuseError(&errorPixels, x + 1, y + 1, pixelError, 1.0 / 16)
+				case DitherAtkinson:
+					// Only 3/4 of the error is passed on, which keeps highlights and shadows clean
+					diffuseError(&errorPixels, x + 1, y, pixelError, 1.0 / 8)
+					diffuseError(&errorPixels, x + 2, y, pixelError, 1.0 / 8)
+					diffuseError(&errorPixels, x - 1, y + 1, pixelError, 1.0 / 8)
+					diffuseError(&errorPixels, x, y + 1, pixelError, 1.0 / 8)
+					diffuseError(&errorPixels, x + 1, y + 1, pixelError, 1.0 / 8)
+					diffuseError(&errorPixels, x, y + 2, pixelError, 1.0 / 8)
+			}
+		}
+	}
+	return colorIndexes
+}
+
+// diffuseError adds part of a pixel's error to a neighbour, if it is inside the frame
+func diffuseError(pixels *[192][256]quantizeColor, x int, y int, pixelError quantizeColor, weight float64) {
+	if x < 0 || x >= 256 || y >= 192 {
+		return
+	}
+	for channel := 0; channel < 3; channel++ {
+		pixels[y][x][channel] += pixelError[channel] * weight
+	}
+}
+
+func closestColor(pixel quantizeColor, colors []quantizeColor) int {
+	closest := 0
+	for i := 1; i < len(colors); i++ {
+		if colorDistance(pixel, colors[i]) < colorDistance(pixel, colors[closest]) {
+			closest = i
+		}
+	}
+	return closest
+}
+
+func colorDistance(a quantizeColor, b quantizeColor) float64 {
+	return (a[0] - b[0]) * (a[0] - b[0]) + (a[1] - b[1]) * (a[1] - b[1]) + (a[2] - b[2]) * (a[2] - b[2])
+}
+
+// mixDistance returns the squared distance from pixel to the closest mix of colors a and b
+func mixDistance(pixel quantizeColor, a quantizeColor, b quantizeColor) float64 {
+	lengthSquared := colorDistance(a, b)
+	if lengthSquared == 0 {
+		return colorDistance(pixel, a)
+	}
+	mix := ((pixel[0] - a[0]) * (b[0] - a[0]) + (pixel[1] - a[1]) * (b[1] - a[1]) + (pixel[2] - a[2]) * (b[2] - a[2])) / lengthSquared
+	mix = math.Max(0, math.Min(1, mix))
+	return colorDistance(pixel, quantizeColor{a[0] + (b[0] - a[0]) * mix, a[1] + (b[1] - a[1]) * mix, a[2] + (b[2] - a[2]) * mix})
+}
+
+// frameColor returns one of framePaletteColors as a quantizeColor
+func frameColor(colorIndex uint8) quantizeColor {
+	rgba := framePaletteColors[colorIndex].(color.RGBA)
+	return rgbToQuantizeColor(float64(rgba.R) / 255, float64(rgba.G) / 255, float64(rgba.B) / 255)
+}
+
+func rgbToQuantizeColor(red float64, green float64, blue float64) quantizeColor {
+	luma := 0.299 * red + 0.587 * green + 0.114 * blue
+	return quantizeColor{luma, red - luma, blue - luma}
+}
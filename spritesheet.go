@@ -0,0 +1,155 @@
+package ppm
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+)
+
+type SpriteSheetOptions struct {
+	MaxWidth int // Widest the sheet may be in pixels, or ErrSpriteSheetWidth if one padded frame doesn't fit; 0 lays the frames out in a square grid
+	Padding int // Transparent pixels around and between frames
+	Scale int // Integer scale factor, 1 if unset
+	Labels bool // Draw each frame's number in its top left corner
+}
+
+// SpriteAtlas describes where each frame sits on a sprite sheet
+type SpriteAtlas struct {
+	Width int `json:"width"`
+	Height int `json:"height"`
+	Frames []SpriteAtlasFrame `json:"frames"`
+}
+
+type SpriteAtlasFrame struct {
+	Frame int `json:"frame"`
+	X int `json:"x"`
+	Y int `json:"y"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+	Duration float64 `json:"duration"` // Milliseconds
+}
+
+// 3x5 bitmap digits for frame labels, one row per byte with the leftmost pixel in bit 2
+var labelFont = [10][5]byte{
+	{0x7, 0x5, 0x5, 0x5, 0x7},
+	{0x2, 0x6, 0x2, 0x2, 0x7},
+	{0x7, 0x1, 0x7, 0x4, 0x7},
+	{0x7, 0x1, 0x7, 0x1, 0x7},
+	{0x5, 0x5, 0x7, 0x1, 0x1},
+	{0x7, 0x4, 0x7, 0x1, 0x7},
+	{0x7, 0x4, 0x7, 0x5, 0x7},
+	{0x7, 0x1, 0x1, 0x1, 0x1},
+	{0x7, 0x5, 0x7, 0x5, 0x7},
+	{0x7, 0x5, 0x7, 0x1, 0x7},
+}
+
+// SpriteSheet renders every frame of p onto one image laid out in a grid, along with an atlas of
+// where each frame is and how long it is shown for
+func SpriteSheet(p *PPM, opts SpriteSheetOptions) (*image.Paletted, *SpriteAtlas, error) {
+	frameCount := p.frameCount()
+	if frameCount == 0 {
+		return nil, nil, ErrNoFrameData
+	}
+	timing := p.Timing()
+	scale := opts.Scale
+	if scale < 1 {
+		scale = 1
+	}
+	padding := opts.Padding
+	if padding < 0 {
+		padding = 0
+	}
+	frameWidth := 256 * scale
+	frameHeight := 192 * scale
+
+	columns := int(math.Ceil(math.Sqrt(float64(frameCount))))
+	if opts.MaxWidth > 0 {
+		columns = (opts.MaxWidth - padding) / (frameWidth + padding)
+		if columns < 1 {
+			return nil, nil, ErrSpriteSheetWidth
+		}
+	}
+	if columns > frameCount {
+		columns = frameCount
+	}
+	rows := (frameCount + columns - 1) / columns
+
+	sheetPalette := append(color.Palette{}, framePaletteColors...)
+	transparentIndex := uint8(len(sheetPalette))
+	sheetPalette = append(sheetPalette, color.RGBA{})
+
+	atlas := &SpriteAtlas{
+		Width: padding + columns * (frameWidth + padding),
+		Height: padding + rows * (frameHeight + padding),
+		Frames: make([]SpriteAtlasFrame, frameCount),
+	}
+	sheet := image.NewPaletted(image.Rect(0, 0, atlas.Width, atlas.Height), sheetPalette)
+	for i := range sheet.Pix {
+		sheet.Pix[i] = transparentIndex
+	}
+
+	frameDuration := float64(timing.FrameDuration().Microseconds()) / 1000
+	for frameN := 0; frameN < frameCount; frameN++ {
+		frameLayers, err := p.FrameLayers(frameN)
+		if err != nil {
+			return nil, nil, err
+		}
+		frameImage := getFrameImage(frameLayers, p.FrameData.layerHidden(), scale)
+
+		x := padding + (frameN % columns) * (frameWidth + padding)
+		y := padding + (frameN / columns) * (frameHeight + padding)
+		for line := 0; line < frameHeight; line++ {
+			copy(sheet.Pix[sheet.PixOffset(x, y + line):], frameImage.Pix[frameImage.PixOffset(0, line):frameImage.PixOffset(frameWidth, line)])
+		}
+		if opts.Labels {
+			drawLabel(sheet, x, y, scale, strconv.Itoa(frameN))
+		}
+
+		atlas.Frames[frameN] = SpriteAtlasFrame{Frame: frameN, X: x, Y: y, Width: frameWidth, Height: frameHeight, Duration: frameDuration}
+	}
+	return sheet, atlas, nil
+}
+
+// drawLabel draws text in black on a white box at x, y, with each font pixel scale pixels wide
+func drawLabel(sheet *image.Paletted, x int, y int, scale int, text string) {
+	boxWidth := (len(text) * 4 + 1) * scale
+	boxHeight := 7 * scale
+	for boxY := 0; boxY < boxHeight; boxY++ {
+		for boxX := 0; boxX < boxWidth; boxX++ {
+			sheet.SetColorIndex(x + boxX, y + boxY, framePaletteWhite)
+		}
+	}
+	for charN, char := range text {
+		glyph := labelFont[char - '0']
+		for glyphY := 0; glyphY < 5; glyphY++ {
+			for glyphX := 0; glyphX < 3; glyphX++ {
+				if (glyph[glyphY] >> uint(2 - glyphX)) & 0x1 == 0 {
+					continue
+				}
+				pixelX := x + (1 + charN * 4 + glyphX) * scale
+				pixelY := y + (1 + glyphY) * scale
+				for scaleY := 0; scaleY < scale; scaleY++ {
+					for scaleX := 0; scaleX < scale; scaleX++ {
+						sheet.SetColorIndex(pixelX + scaleX, pixelY + scaleY, framePaletteBlack)
+					}
+				}
+			}
+		}
+	}
+}
+
+// WriteSpriteSheet writes the sprite sheet of p as a PNG to imageWriter and its atlas as JSON to atlasWriter
+func WriteSpriteSheet(imageWriter io.Writer, atlasWriter io.Writer, p *PPM, opts SpriteSheetOptions) error {
+	sheet, atlas, err := SpriteSheet(p, opts)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(imageWriter, sheet); err != nil {
+		return err
+	}
+	return json.NewEncoder(atlasWriter).Encode(atlas)
+}
@@ -0,0 +1,53 @@
+package ppm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSpriteSheetMaxWidth(t *testing.T) {
+	frame := fixtureFrame{keyframe: true, paper: PaperWhite, pens: [2]byte{1, 1}}
+	ppmData, err := DecodeBytes(fixturePPM{frames: []fixtureFrame{frame, frame, frame}}.build())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	widthCases := []struct {
+		name string
+		opts SpriteSheetOptions
+		wantColumns int
+		wantErr error
+	}{
+		{"square", SpriteSheetOptions{}, 2, nil},
+		{"one-row", SpriteSheetOptions{MaxWidth: 1000}, 3, nil},
+		{"exact-fit", SpriteSheetOptions{MaxWidth: 2 + 2 * (256 + 2), Padding: 2}, 2, nil},
+		{"one-column", SpriteSheetOptions{MaxWidth: 256 * 2 - 1}, 1, nil},
+		{"too-narrow", SpriteSheetOptions{MaxWidth: 255}, 0, ErrSpriteSheetWidth},
+		{"too-narrow-padded", SpriteSheetOptions{MaxWidth: 256, Padding: 1}, 0, ErrSpriteSheetWidth},
+		{"too-narrow-scaled", SpriteSheetOptions{MaxWidth: 300, Scale: 2}, 0, ErrSpriteSheetWidth},
+	}
+
+	for _, widthCase := range widthCases {
+		t.Run(widthCase.name, func(t *testing.T) {
+			sheet, atlas, err := SpriteSheet(ppmData, widthCase.opts)
+			if !errors.Is(err, widthCase.wantErr) {
+				t.Fatalf("error %v, want %v", err, widthCase.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if widthCase.opts.MaxWidth > 0 && sheet.Bounds().Dx() > widthCase.opts.MaxWidth {
+				t.Fatalf("sheet is %d pixels wide, more than MaxWidth", sheet.Bounds().Dx())
+			}
+			columns := 0
+			for _, atlasFrame := range atlas.Frames {
+				if atlasFrame.Y == atlas.Frames[0].Y {
+					columns++
+				}
+			}
+			if columns != widthCase.wantColumns {
+				t.Fatalf("%d columns, want %d", columns, widthCase.wantColumns)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package ppm
+
+import (
+	"time"
+)
+
+// Frame rates for each frame speed, as numerator/denominator frames per second, indexed by SoundMeta.FrameSpeed
+var frameRateFractions = [9][2]int{
+	{0, 1},
+	{1, 2}, // 0.5 fps
+	{1, 1},
+	{2, 1},
+	{4, 1},
+	{6, 1},
+	{12, 1},
+	{20, 1},
+	{30, 1},
+}
+
+// Timing maps a flipnote's frame speed to real playback rates, for sharing between players and exporters
+type Timing struct {
+	FrameSpeed int // 1 to 8, as in SoundMeta.FrameSpeed
+	FrameCount int
+}
+
+// Timing returns the playback timing of the flipnote
+func (ppmData *PPM) Timing() Timing {
+	return Timing{FrameSpeed: ppmData.SoundData.SoundMeta.FrameSpeed, FrameCount: ppmData.Meta.FrameCount}
+}
+
+// FrameDuration returns how long each frame is shown for, or 0 if the frame speed is not known
+func (ppmData *PPM) FrameDuration() time.Duration {
+	return ppmData.Timing().FrameDuration()
+}
+
+// Duration returns the play time of every frame, or 0 if the frame speed is not known
+func (ppmData *PPM) Duration() time.Duration {
+	return ppmData.Timing().Duration()
+}
+
+// Valid reports whether the frame speed is one Flipnote Studio can play back at
+func (timing Timing) Valid() bool {
+	return timing.FrameSpeed >= 1 && timing.FrameSpeed <= 8
+}
+
+// FrameRateFraction returns the exact frame rate as numerator/denominator frames per second.
+// An unknown frame speed returns 0/1.
+func (timing Timing) FrameRateFraction() (numerator int, denominator int) {
+	if !timing.Valid() {
+		return 0, 1
+	}
+	fraction := frameRateFractions[timing.FrameSpeed]
+	return fraction[0], fraction[1]
+}
+
+// FPS returns the frame rate in frames per second: 0.5, 1, 2, 4, 6, 12, 20 or 30
+func (timing Timing) FPS() float64 {
+	numerator, denominator := timing.FrameRateFraction()
+	return float64(numerator) / float64(denominator)
+}
+
+// FrameDuration returns how long each frame is shown for
+func (timing Timing) FrameDuration() time.Duration {
+	return timing.FrameStart(1)
+}
+
+// Duration returns the play time of all FrameCount frames
+func (timing Timing) Duration() time.Duration {
+	return timing.FrameStart(timing.FrameCount)
+}
+
+// FrameStart returns when frame n starts playing
+func (timing Timing) FrameStart(n int) time.Duration {
+	numerator, denominator := timing.FrameRateFraction()
+	if numerator == 0 {
+		return 0
+	}
+	return time.Duration(int64(n) * int64(time.Second) * int64(denominator) / int64(numerator))
+}
+
+// FrameToSample returns the offset of the first audio sample played with frame n, at SampleRate
+func (timing Timing) FrameToSample(n int) int {
+	numerator, denominator := timing.FrameRateFraction()
+	if numerator == 0 {
+		return 0
+	}
+	return int((int64(n) * SampleRate * int64(denominator) + int64(numerator) / 2) / int64(numerator))
+}
+
+// SampleToFrame returns the frame shown while the audio sample at offset sample plays. Frames start
+// on the samples FrameToSample rounds them to, so SampleToFrame(FrameToSample(n)) is always n.
+func (timing Timing) SampleToFrame(sample int) int {
+	numerator, denominator := timing.FrameRateFraction()
+	if numerator == 0 {
+		return 0
+	}
+	// The last frame n with n * SampleRate * denominator / numerator + 1/2 < sample + 1
+	return int(((2 * int64(sample) + 1) * int64(numerator) - 1) / (2 * SampleRate * int64(denominator)))
+}
@@ -0,0 +1,61 @@
+package ppm
+
+import (
+	"image"
+	"math"
+	"testing"
+	"time"
+)
+
+// Timing counts frames from Meta, which is all a flipnote built in memory sets
+func TestTimingFrameCount(t *testing.T) {
+	frames := []image.Image{image.NewGray(image.Rect(0, 0, 256, 192)), image.NewGray(image.Rect(0, 0, 256, 192))}
+	ppmData, err := FromImages(frames, ImportOptions{FrameSpeed: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timing := ppmData.Timing(); timing.FrameCount != 2 || ppmData.Duration() != time.Second / 2 {
+		t.Fatalf("Timing() = %+v, Duration() = %v", timing, ppmData.Duration())
+	}
+}
+
+func TestTimingSpeeds(t *testing.T) {
+	speedCases := []struct {
+		frameSpeed int
+		fps float64
+		frameDuration time.Duration
+	}{
+		{1, 0.5, 2 * time.Second},
+		{2, 1, time.Second},
+		{3, 2, 500 * time.Millisecond},
+		{4, 4, 250 * time.Millisecond},
+		{5, 6, 166666666},
+		{6, 12, 83333333},
+		{7, 20, 50 * time.Millisecond},
+		{8, 30, 33333333},
+	}
+
+	for _, speedCase := range speedCases {
+		timing := Timing{FrameSpeed: speedCase.frameSpeed, FrameCount: 999}
+		if !timing.Valid() || timing.FPS() != speedCase.fps || timing.FrameDuration() != speedCase.frameDuration {
+			t.Errorf("speed %d: FPS() = %v, FrameDuration() = %v", speedCase.frameSpeed, timing.FPS(), timing.FrameDuration())
+			continue
+		}
+		for frameN := 0; frameN < 999; frameN++ {
+			firstSample := timing.FrameToSample(frameN)
+			if wantSample := int(math.Round(float64(frameN) * SampleRate / speedCase.fps)); firstSample != wantSample {
+				t.Fatalf("speed %d: FrameToSample(%d) = %d, want %d", speedCase.frameSpeed, frameN, firstSample, wantSample)
+			}
+			// Every sample up to the next frame's first belongs to this frame
+			nextSample := timing.FrameToSample(frameN + 1)
+			if timing.SampleToFrame(firstSample) != frameN || timing.SampleToFrame(nextSample - 1) != frameN {
+				t.Fatalf("speed %d: frame %d starts at sample %d, which maps back to frame %d, and ends at %d, frame %d", speedCase.frameSpeed,
+					frameN, firstSample, timing.SampleToFrame(firstSample), nextSample - 1, timing.SampleToFrame(nextSample - 1))
+			}
+		}
+	}
+
+	if timing := (Timing{FrameSpeed: 0}); timing.Valid() || timing.FPS() != 0 || timing.FrameToSample(5) != 0 || timing.SampleToFrame(5000) != 0 {
+		t.Error("an unknown frame speed has a timing")
+	}
+}
@@ -0,0 +1,89 @@
+package ppm
+
+import "testing"
+
+// patternLines fills every line of both layers with raw chunks that differ from line to line
+func patternLines() [2]map[int]fixtureLine {
+	lines := [2]map[int]fixtureLine{{}, {}}
+	for layer := 0; layer < 2; layer++ {
+		for line := 0; line < 192; line++ {
+			chunks := [32]byte{}
+			for chunk := range chunks {
+				chunks[chunk] = byte(line * 7 + chunk * 13 + layer * 101)
+			}
+			lines[layer][line] = rawLine(chunks)
+		}
+	}
+	return lines
+}
+
+// A moved diff frame is the previous frame shifted by the translation, with whatever is moved
+// off the screen dropped and the uncovered edge left empty, then XORed with the diff's own lines
+func TestTranslatedDiff(t *testing.T) {
+	translateCases := []struct {
+		name string
+		translateX int8
+		translateY int8
+		lines [2]map[int]fixtureLine
+	}{
+		{"right", 17, 0, [2]map[int]fixtureLine{}},
+		{"left", -17, 0, [2]map[int]fixtureLine{}},
+		{"down", 0, 9, [2]map[int]fixtureLine{}},
+		{"up", 0, -9, [2]map[int]fixtureLine{}},
+		{"right-up", 3, -100, [2]map[int]fixtureLine{}},
+		{"left-down", -120, 101, [2]map[int]fixtureLine{}},
+		{"off-screen", 127, -128, [2]map[int]fixtureLine{}},
+		{"with-lines", -5, 6, [2]map[int]fixtureLine{{0: rawLine(stripedChunks(0xFF)), 100: chunkedLine(map[int]byte{0: 0x0F})}, {191: invertedLine(map[int]byte{})}}},
+	}
+
+	for _, translateCase := range translateCases {
+		t.Run(translateCase.name, func(t *testing.T) {
+			keyframe := fixtureFrame{keyframe: true, paper: PaperWhite, pens: [2]byte{2, 3}, lines: patternLines()}
+			diff := fixtureFrame{translated: true, translateX: translateCase.translateX, translateY: translateCase.translateY,
+				paper: PaperWhite, pens: [2]byte{2, 3}, lines: translateCase.lines}
+			ppmData, err := DecodeBytes(fixturePPM{frames: []fixtureFrame{keyframe, diff}}.build())
+			if err != nil {
+				t.Fatal(err)
+			}
+			prevLayers, err := ppmData.FrameLayers(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frameLayers, err := ppmData.FrameLayers(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frameLayers.TranslateX != int(translateCase.translateX) || frameLayers.TranslateY != int(translateCase.translateY) {
+				t.Fatalf("translation decoded as %d, %d", frameLayers.TranslateX, frameLayers.TranslateY)
+			}
+
+			// The diff's own lines, decoded on a keyframe of their own
+			ownLines, err := DecodeBytes(fixturePPM{frames: []fixtureFrame{{keyframe: true, paper: PaperWhite, pens: [2]byte{2, 3}, lines: translateCase.lines}}}.build())
+			if err != nil {
+				t.Fatal(err)
+			}
+			diffLayers, err := ownLines.FrameLayers(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for layer := 0; layer < 2; layer++ {
+				for line := 0; line < 192; line++ {
+					for pixelPosition := 0; pixelPosition < 256; pixelPosition++ {
+						prevLine := line - int(translateCase.translateY)
+						prevPixel := pixelPosition - int(translateCase.translateX)
+						want := false
+						if prevLine >= 0 && prevLine < 192 && prevPixel >= 0 && prevPixel < 256 {
+							want = prevLayers.Layers[layer][prevLine][prevPixel] != 0
+						}
+						want = want != (diffLayers.Layers[layer][line][pixelPosition] != 0)
+						got := frameLayers.Layers[layer][line][pixelPosition]
+						if (got != 0) != want || (got != 0 && got != byte(layer + 2)) {
+							t.Fatalf("layer %d pixel (%d, %d) = %d", layer, pixelPosition, line, got)
+						}
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package ppm
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+)
+
+// WriteY4M streams p as YUV4MPEG2 with 4:2:0 chroma at the flipnote's exact frame rate, scaled
+// up scale times, for piping into external encoders. Frames are decoded one at a time through
+// FrameLayers, so only the frame being written is held in memory.
+func WriteY4M(w io.Writer, p *PPM, scale int) error {
+	timing := p.Timing()
+	if !timing.Valid() {
+		return ErrBadFrameSpeed
+	}
+	frameCount := p.frameCount()
+	if frameCount == 0 {
+		return ErrNoFrameData
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	width := 256 * scale
+	height := 192 * scale
+	numerator, denominator := timing.FrameRateFraction()
+
+	// color.RGBToYCbCr gives full range JFIF YCbCr, but encoders read YUV4MPEG2 as limited range
+	// BT.601, so it is squeezed into 16-235 for luma and 16-240 for chroma. C420jpeg only says
+	// where the chroma samples sit.
+	paletteY := make([]int, len(framePaletteColors))
+	paletteCb := make([]int, len(framePaletteColors))
+	paletteCr := make([]int, len(framePaletteColors))
+	for i, paletteColor := range framePaletteColors {
+		rgba := paletteColor.(color.RGBA)
+		y, cb, cr := color.RGBToYCbCr(rgba.R, rgba.G, rgba.B)
+		paletteY[i] = 16 + int(math.Round(float64(y) * 219 / 255))
+		paletteCb[i] = 128 + int(math.Round((float64(cb) - 128) * 224 / 255))
+		paletteCr[i] = 128 + int(math.Round((float64(cr) - 128) * 224 / 255))
+	}
+
+	if _, err := fmt.Fprintf(w, "YUV4MPEG2 W%d H%d F%d:%d Ip A1:1 C420jpeg\n", width, height, numerator, denominator); err != nil {
+		return err
+	}
+
+	planeY := make([]byte, width * height)
+	planeCb := make([]byte, width / 2 * height / 2)
+	planeCr := make([]byte, width / 2 * height / 2)
+	for frameN := 0; frameN < frameCount; frameN++ {
+		frameLayers, err := p.FrameLayers(frameN)
+		if err != nil {
+			return err
+		}
+		frameImage := getFrameImage(frameLayers, p.FrameData.layerHidden(), scale)
+
+		for i, paletteIndex := range frameImage.Pix {
+			planeY[i] = byte(paletteY[paletteIndex])
+		}
+		// Each chroma sample is the average of a 2x2 block of pixels
+		for chromaY := 0; chromaY < height / 2; chromaY++ {
+			for chromaX := 0; chromaX < width / 2; chromaX++ {
+				cb, cr := 0, 0
+				for _, pixOffset := range []int{
+					frameImage.PixOffset(chromaX * 2, chromaY * 2),
+					frameImage.PixOffset(chromaX * 2 + 1, chromaY * 2),
+					frameImage.PixOffset(chromaX * 2, chromaY * 2 + 1),
+					frameImage.PixOffset(chromaX * 2 + 1, chromaY * 2 + 1),
+				} {
+					cb += paletteCb[frameImage.Pix[pixOffset]]
+					cr += paletteCr[frameImage.Pix[pixOffset]]
+				}
+				planeCb[chromaY * width / 2 + chromaX] = byte((cb + 2) / 4)
+				planeCr[chromaY * width / 2 + chromaX] = byte((cr + 2) / 4)
+			}
+		}
+
+		for _, data := range [][]byte{[]byte("FRAME\n"), planeY, planeCb, planeCr} {
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package ppm
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Paper colours come out in limited range, as encoders read YUV4MPEG2 without a colour range tag
+func TestWriteY4M(t *testing.T) {
+	frames := []fixtureFrame{{keyframe: true, paper: PaperWhite, pens: [2]byte{2, 3}}, {keyframe: true, paper: PaperBlack, pens: [2]byte{2, 3}}}
+	ppmData, err := DecodeBytes(fixturePPM{frames: frames}.build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	y4m := &bytes.Buffer{}
+	if err := WriteY4M(y4m, ppmData, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	header := "YUV4MPEG2 W256 H192 F12:1 Ip A1:1 C420jpeg\n"
+	if !bytes.HasPrefix(y4m.Bytes(), []byte(header)) {
+		t.Fatalf("header %q", y4m.Bytes()[:len(header)])
+	}
+	frameSize := len("FRAME\n") + 256 * 192 * 3 / 2
+	if y4m.Len() != len(header) + frameSize * 2 {
+		t.Fatalf("%d bytes, want %d", y4m.Len(), len(header) + frameSize * 2)
+	}
+	for frameN, wantY := range []byte{235, 28} { // White, and the (14, 14, 14) Flipnote uses for black
+		frame := y4m.Bytes()[len(header) + frameSize * frameN:]
+		planeY := frame[len("FRAME\n"):]
+		planeCb := planeY[256 * 192:]
+		planeCr := planeCb[128 * 96:]
+		if planeY[0] != wantY || planeCb[0] != 128 || planeCr[0] != 128 {
+			t.Errorf("frame %d: paper is %d, %d, %d, want %d, 128, 128", frameN, planeY[0], planeCb[0], planeCr[0], wantY)
+		}
+	}
+}